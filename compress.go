@@ -0,0 +1,288 @@
+package rollingwriter
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compressor compress the rolling file
+type Compressor interface {
+	// Extension return the compressed file extension with the dot, e.g. ".gz"
+	Extension() string
+	// NewWriter return a writer compress the data into w, the data will be
+	// flushed when the writer closed
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// LevelCompressor is the Compressor supports the compression level
+type LevelCompressor interface {
+	Compressor
+	// NewLevelWriter return a writer compress the data into w with the level,
+	// the level meaning depends on the codec
+	NewLevelWriter(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+var (
+	// CompressConcurrency defined the max number of compressions running at the same time
+	// for all the writers in process. It should be set before the first compression
+	CompressConcurrency = runtime.NumCPU()
+
+	compressPoolOnce sync.Once
+	compressPool     chan struct{}
+	compressRunning  int64
+	compressWaiting  int64
+)
+
+// CompressStats return the number of compressions running and waiting in the process
+func CompressStats() (running, waiting int) {
+	return int(atomic.LoadInt64(&compressRunning)), int(atomic.LoadInt64(&compressWaiting))
+}
+
+// doCompress run the compression after acquired the slot of the process-wide pool,
+// at most CompressConcurrency compressions run at the same time
+func doCompress(compress func() error) error {
+	compressPoolOnce.Do(func() {
+		n := CompressConcurrency
+		if n <= 0 {
+			n = 1
+		}
+		compressPool = make(chan struct{}, n)
+	})
+
+	atomic.AddInt64(&compressWaiting, 1)
+	compressPool <- struct{}{}
+	atomic.AddInt64(&compressWaiting, -1)
+	atomic.AddInt64(&compressRunning, 1)
+	defer func() {
+		atomic.AddInt64(&compressRunning, -1)
+		<-compressPool
+	}()
+	return compress()
+}
+
+var (
+	compressorsLock sync.RWMutex
+	compressors     = map[string]Compressor{
+		"gzip": GzipCompressor{},
+		"zstd": ZstdCompressor{},
+		"lz4":  LZ4Compressor{},
+	}
+)
+
+// RegisterCompressor makes a compressor available by the name, then it can be
+// chosen with Compression in the Config.
+// If RegisterCompressor is called twice with the same name or if compressor is nil, it panics.
+func RegisterCompressor(name string, compressor Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	if compressor == nil {
+		panic("rollingwriter: register compressor is nil")
+	}
+	if _, dup := compressors[name]; dup {
+		panic("rollingwriter: register compressor called twice for " + name)
+	}
+	compressors[name] = compressor
+}
+
+// compressor return the compressor configured, nil for no compression
+func (c *Config) compressor() (Compressor, error) {
+	if c.Compressor != nil {
+		return c.Compressor, nil
+	}
+	if c.Compression != "" {
+		compressorsLock.RLock()
+		compressor, ok := compressors[c.Compression]
+		compressorsLock.RUnlock()
+		if !ok {
+			return nil, ErrUnknownCompressor
+		}
+		return compressor, nil
+	}
+	if c.Compress {
+		return GzipCompressor{}, nil
+	}
+	return nil, nil
+}
+
+// compressFile compress the oldfile into cmpname and sync it
+func compressFile(c *Config, oldfile *os.File, cmpname string) error {
+	compressor, err := c.compressor()
+	if err != nil {
+		return err
+	}
+	if compressor == nil {
+		compressor = GzipCompressor{}
+	}
+
+	cmpfile, err := os.OpenFile(cmpname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, DefaultFileMode)
+	if err != nil {
+		return err
+	}
+	defer cmpfile.Close()
+
+	var gw io.WriteCloser
+	if lc, ok := compressor.(LevelCompressor); ok && c.CompressLevel != 0 {
+		gw, err = lc.NewLevelWriter(cmpfile, c.CompressLevel)
+	} else {
+		gw, err = compressor.NewWriter(cmpfile)
+	}
+	if err == nil {
+		if _, err = oldfile.Seek(0, 0); err == nil {
+			_, err = io.Copy(gw, oldfile)
+		}
+		if errC := gw.Close(); err == nil {
+			err = errC
+		}
+	}
+	if err == nil {
+		err = cmpfile.Sync()
+	}
+	if err != nil {
+		cmpfile.Close()
+		if errR := os.Remove(cmpname); errR != nil {
+			return errR
+		}
+		return err
+	}
+	return cmpfile.Close()
+}
+
+// compressRolling compress the rolling file from its tempfile [file].tmp.
+// The data is compressed into [file].part and renamed to file after synced,
+// so the file is always complete. The tempfile is removed at last
+func compressRolling(c *Config, file string) error {
+	oldfile, err := os.Open(file + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer oldfile.Close()
+
+	if err := compressFile(c, oldfile, file+".part"); err != nil {
+		return err
+	}
+	if err := os.Rename(file+".part", file); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(file))
+	oldfile.Close()
+	return os.Remove(file + ".tmp")
+}
+
+// recoverCompress finish or roll back the compressions interrupted by crash.
+// The part files are removed, the tempfiles are removed if the compressed file
+// is in place, otherwise compressed again or renamed back if compress disabled
+func recoverCompress(c *Config) error {
+	dir, err := os.ReadDir(c.LogPath)
+	if err != nil {
+		return err
+	}
+	manifest, err := readManifest(c)
+	if err != nil {
+		return err
+	}
+	rolled := make(map[string]bool, len(manifest))
+	for _, file := range manifest {
+		rolled[filepath.Clean(file)] = true
+	}
+
+	compressor, err := c.compressor()
+	if err != nil {
+		return err
+	}
+	for _, fi := range dir {
+		ext := filepath.Ext(fi.Name())
+		if fi.IsDir() || ext != ".tmp" && ext != ".part" {
+			continue
+		}
+		file := filepath.Clean(path.Join(c.LogPath, strings.TrimSuffix(fi.Name(), ext)))
+		if !rolled[file] && !c.isBackup(filepath.Base(file)) {
+			continue
+		}
+
+		_, err := os.Stat(file)
+		switch {
+		case ext == ".part":
+			err = os.Remove(file + ext)
+		case err == nil:
+			// compressed file is in place
+			err = os.Remove(file + ext)
+		case !os.IsNotExist(err):
+		case compressor != nil:
+			err = doCompress(func() error { return compressRolling(c, file) })
+		default:
+			err = os.Rename(file+ext, file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GzipCompressor compress the file with gzip
+type GzipCompressor struct{}
+
+// Extension return .gz
+func (GzipCompressor) Extension() string { return ".gz" }
+
+// NewWriter return the gzip writer
+func (GzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// NewLevelWriter return the gzip writer with level from 1 to 9
+func (GzipCompressor) NewLevelWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+// ZstdCompressor compress the file with zstd
+type ZstdCompressor struct{}
+
+// Extension return .zst
+func (ZstdCompressor) Extension() string { return ".zst" }
+
+// NewWriter return the zstd writer
+func (ZstdCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+// NewLevelWriter return the zstd writer with level from 1 to 22
+func (ZstdCompressor) NewLevelWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < 1 || level > 22 {
+		return nil, ErrInvalidArgument
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+}
+
+// LZ4Compressor compress the file with lz4 frame format
+type LZ4Compressor struct{}
+
+// Extension return .lz4
+func (LZ4Compressor) Extension() string { return ".lz4" }
+
+// NewWriter return the lz4 writer
+func (LZ4Compressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return lz4.NewWriter(w), nil
+}
+
+// NewLevelWriter return the lz4 writer with level from 1 to 9
+func (LZ4Compressor) NewLevelWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < 1 || level > 9 {
+		return nil, ErrInvalidArgument
+	}
+	zw := lz4.NewWriter(w)
+	if err := zw.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(1 << (8 + level - 1)))); err != nil {
+		return nil, err
+	}
+	return zw, nil
+}
@@ -0,0 +1,169 @@
+package rollingwriter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressorFileFormat(t *testing.T) {
+	c := &Config{LogPath: "./", FileName: "file", FileExtension: "log", TimeTagFormat: "200601021504"}
+	start := time.Now()
+	timetag := start.Format(c.TimeTagFormat)
+
+	c.Compress = true
+	assert.Equal(t, path.Join("./", "file.log.gz."+timetag), c.fileFormat(start, ""))
+	c.Compression = "zstd"
+	assert.Equal(t, path.Join("./", "file.log.zst."+timetag), c.fileFormat(start, ""))
+	c.Compressor = LZ4Compressor{}
+	assert.Equal(t, path.Join("./", "file.log.lz4."+timetag), c.fileFormat(start, ""))
+
+	c.FileFormatter = func(time.Time) string { return "./file.log" }
+	assert.Equal(t, "./file.log.lz4", c.fileFormat(start, ""))
+
+	_, err := NewWriter(WithLogPath(t.TempDir()), WithCompression("not-exist"))
+	assert.Equal(t, ErrUnknownCompressor, err)
+}
+
+func TestCompressors(t *testing.T) {
+	readers := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		"lz4":  func(r io.Reader) (io.Reader, error) { return lz4.NewReader(r), nil },
+	}
+
+	for name, reader := range readers {
+		name, reader := name, reader
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := NewWriter(WithLogPath(dir), WithFileName("compress"), WithCompression(name))
+			assert.Nil(t, err)
+
+			data := bytes.Repeat([]byte("compress the rolling file\n"), 1000)
+			w.Write(data)
+			assert.Nil(t, w.Rotate())
+			w.Close()
+
+			// wait for the background compression
+			var b []byte
+			for i := 0; i < 100; i++ {
+				time.Sleep(10 * time.Millisecond)
+				files, _ := filepath.Glob(path.Join(dir, "compress.log.*"))
+				if len(files) != 1 {
+					continue
+				}
+				file, err := os.Open(files[0])
+				if err != nil {
+					continue
+				}
+				if r, err := reader(file); err == nil {
+					b, _ = io.ReadAll(r)
+				}
+				file.Close()
+				if bytes.Equal(data, b) {
+					break
+				}
+			}
+			assert.Equal(t, data, b)
+		})
+	}
+}
+
+func TestCompressLevel(t *testing.T) {
+	data := bytes.Repeat([]byte("compress with level\n"), 1000)
+	compressors := map[LevelCompressor]int{GzipCompressor{}: 9, ZstdCompressor{}: 19, LZ4Compressor{}: 9}
+	for compressor, level := range compressors {
+		var buf bytes.Buffer
+		w, err := compressor.NewLevelWriter(&buf, level)
+		assert.Nil(t, err)
+		w.Write(data)
+		assert.Nil(t, w.Close())
+		assert.True(t, buf.Len() < len(data), compressor.Extension())
+	}
+
+	_, err := LZ4Compressor{}.NewLevelWriter(io.Discard, 10)
+	assert.Equal(t, ErrInvalidArgument, err)
+	_, err = ZstdCompressor{}.NewLevelWriter(io.Discard, 23)
+	assert.Equal(t, ErrInvalidArgument, err)
+
+	for _, compression := range []string{"gzip", "zstd", "lz4"} {
+		_, err = NewWriter(WithLogPath(t.TempDir()), WithCompression(compression), WithCompressLevel(42))
+		assert.Equal(t, ErrInvalidArgument, err, compression)
+	}
+}
+
+func TestCompressPool(t *testing.T) {
+	doCompress(func() error { return nil })
+	n := cap(compressPool)
+
+	release := make(chan struct{})
+	done := make(chan struct{}, 2*n)
+	for i := 0; i < 2*n; i++ {
+		go func() {
+			doCompress(func() error { <-release; return nil })
+			done <- struct{}{}
+		}()
+	}
+
+	var running, waiting int
+	for i := 0; i < 100; i++ {
+		if running, waiting = CompressStats(); running == n && waiting == n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, n, running)
+	assert.Equal(t, n, waiting)
+
+	close(release)
+	for i := 0; i < 2*n; i++ {
+		<-done
+	}
+	running, waiting = CompressStats()
+	assert.Equal(t, 0, running+waiting)
+}
+
+func TestRecoverCompress(t *testing.T) {
+	dir := t.TempDir()
+	c := &Config{LogPath: dir, FileName: "recover", FileExtension: "log", TimeTagFormat: "200601021504", Compress: true}
+	data := []byte("interrupted compression\n")
+	interrupted := BackupFilePath(c, time.Now().Add(-3*time.Hour))
+	partial := BackupFilePath(c, time.Now().Add(-2*time.Hour))
+	done := BackupFilePath(c, time.Now().Add(-time.Hour))
+	for _, file := range []string{interrupted + ".tmp", partial + ".tmp", partial + ".part", done, done + ".tmp"} {
+		assert.Nil(t, os.WriteFile(file, data, 0644))
+	}
+
+	w, err := NewWriter(WithLogPath(dir), WithFileName("recover"), WithCompress())
+	assert.Nil(t, err)
+	w.Close()
+
+	for _, file := range []string{interrupted, partial} {
+		f, err := os.Open(file)
+		assert.Nil(t, err)
+		r, err := gzip.NewReader(f)
+		assert.Nil(t, err)
+		b, _ := io.ReadAll(r)
+		assert.Equal(t, data, b)
+		f.Close()
+	}
+	files, _ := filepath.Glob(path.Join(dir, "recover.log.*"))
+	assert.Equal(t, 3, len(files))
+
+	// roll back the tempfile if compress disabled
+	assert.Nil(t, os.Rename(interrupted, interrupted+".tmp"))
+	w, err = NewWriter(WithLogPath(dir), WithFileName("recover"))
+	assert.Nil(t, err)
+	w.Close()
+	_, err = os.Stat(interrupted)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,51 @@
+package rollingwriter
+
+// RotateError is the error occurred while rolling the log file
+type RotateError struct {
+	// Op is the operation failed, e.g. rename
+	Op string
+	// Path is the log file path
+	Path string
+	// Backup is the path the log file rolled to
+	Backup string
+	Err    error
+}
+
+func (e *RotateError) Error() string {
+	return "rollingwriter: rotate " + e.Op + " " + e.Path + " to " + e.Backup + ": " + e.Err.Error()
+}
+
+// Unwrap return the underlying error
+func (e *RotateError) Unwrap() error { return e.Err }
+
+// CompressError is the error occurred while compressing the rolling file
+type CompressError struct {
+	// Source is the rolling file compressed from
+	Source string
+	// Target is the compressed file
+	Target string
+	Err    error
+}
+
+func (e *CompressError) Error() string {
+	return "rollingwriter: compress " + e.Source + " to " + e.Target + ": " + e.Err.Error()
+}
+
+// Unwrap return the underlying error
+func (e *CompressError) Unwrap() error { return e.Err }
+
+// RetentionError is the error occurred while cleaning up the rolling files
+type RetentionError struct {
+	// Op is the operation failed, e.g. remove
+	Op string
+	// Path is the rolling file or the manifest file
+	Path string
+	Err  error
+}
+
+func (e *RetentionError) Error() string {
+	return "rollingwriter: retention " + e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap return the underlying error
+func (e *RetentionError) Unwrap() error { return e.Err }
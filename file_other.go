@@ -0,0 +1,10 @@
+//go:build !windows
+
+package rollingwriter
+
+import "os"
+
+// openFile open the log file like os.OpenFile, the file opened can be renamed
+func openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
@@ -0,0 +1,57 @@
+//go:build windows
+
+package rollingwriter
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFile open the log file like os.OpenFile but share the delete access, so the
+// file can be renamed on rotation while it is still open. The os.OpenFile opens the
+// file without FILE_SHARE_DELETE, then the rename fails with the access denied
+func openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	pathp, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	var access uint32
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		access = syscall.GENERIC_READ
+	case os.O_WRONLY:
+		access = syscall.GENERIC_WRITE
+	case os.O_RDWR:
+		access = syscall.GENERIC_READ | syscall.GENERIC_WRITE
+	}
+	if flag&os.O_APPEND != 0 {
+		access &^= syscall.GENERIC_WRITE
+		access |= syscall.FILE_APPEND_DATA
+	}
+
+	var createmode uint32
+	switch {
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		createmode = syscall.CREATE_NEW
+	case flag&(os.O_CREATE|os.O_TRUNC) == os.O_CREATE|os.O_TRUNC:
+		createmode = syscall.CREATE_ALWAYS
+	case flag&os.O_CREATE == os.O_CREATE:
+		createmode = syscall.OPEN_ALWAYS
+	case flag&os.O_TRUNC == os.O_TRUNC:
+		createmode = syscall.TRUNCATE_EXISTING
+	default:
+		createmode = syscall.OPEN_EXISTING
+	}
+
+	var attrs uint32 = syscall.FILE_ATTRIBUTE_NORMAL
+	if perm&0200 == 0 {
+		attrs = syscall.FILE_ATTRIBUTE_READONLY
+	}
+	sharemode := uint32(syscall.FILE_SHARE_READ | syscall.FILE_SHARE_WRITE | syscall.FILE_SHARE_DELETE)
+	h, err := syscall.CreateFile(pathp, access, sharemode, nil, createmode, attrs, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return os.NewFile(uintptr(h), name), nil
+}
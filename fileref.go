@@ -0,0 +1,57 @@
+package rollingwriter
+
+import (
+	"os"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// fileRef is the log file shared by the writes. It is swapped in RCU style on
+// rotation: the writes pin the current one with the reference count, and the old
+// one is closed after all the writes on it finished, so no write meets a closed file
+type fileRef struct {
+	file *os.File
+	// refs is the number of writes in flight on the file
+	refs int64
+	// size is the bytes written into the file
+	size int64
+}
+
+// release unpin the file acquired
+func (r *fileRef) release() {
+	atomic.AddInt64(&r.refs, -1)
+}
+
+// drain wait for the writes in flight on the file
+func (r *fileRef) drain() {
+	for atomic.LoadInt64(&r.refs) > 0 {
+		runtime.Gosched()
+	}
+}
+
+// current return the current file without pinning
+func (w *Writer) current() *fileRef {
+	return (*fileRef)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&w.ref))))
+}
+
+// acquire pin the current file, it will not be closed until released
+func (w *Writer) acquire() *fileRef {
+	for {
+		ref := w.current()
+		atomic.AddInt64(&ref.refs, 1)
+		// the file may be replaced before pinned, try again with the new one
+		if w.current() == ref {
+			return ref
+		}
+		ref.release()
+	}
+}
+
+// replace swap in the new file, return the old one after the writes on it finished
+func (w *Writer) replace(file *os.File, size int64) *fileRef {
+	ref := &fileRef{file: file, size: size}
+	old := (*fileRef)(atomic.SwapPointer((*unsafe.Pointer)(unsafe.Pointer(&w.ref)), unsafe.Pointer(ref)))
+	old.drain()
+	return old
+}
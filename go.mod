@@ -1,8 +1,15 @@
 module github.com/arthurkiller/rollingwriter
 
-go 1.12
+go 1.22
 
 require (
+	github.com/klauspost/compress v1.18.0
+	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/robfig/cron v1.1.0
 	github.com/stretchr/testify v1.3.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+)
@@ -1,10 +1,14 @@
 package rollingwriter
 
 import (
+	"fmt"
+	"math"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/robfig/cron"
@@ -16,18 +20,59 @@ type manager struct {
 	fire          chan string
 	cr            *cron.Cron
 	context       chan int
-	wg            sync.WaitGroup
 	lock          sync.Mutex
+	// combined is set by TimeAndVolumeRolling, the trigger will be tagged
+	// into the file name and the near-simultaneous triggers will be merged
+	combined bool
+	firing   int32
+	signals  chan os.Signal
+	// precision is the watchdog interval and the combined rolling window
+	precision time.Duration
+}
+
+var (
+	managersLock sync.RWMutex
+	managers     = make(map[string]ManagerBuilder)
+)
+
+// RegisterManager makes a manager builder available by the name, then it can be
+// chosen with RollingManager in the Config.
+// If RegisterManager is called twice with the same name or if builder is nil, it panics.
+func RegisterManager(name string, builder ManagerBuilder) {
+	managersLock.Lock()
+	defer managersLock.Unlock()
+	if builder == nil {
+		panic("rollingwriter: register manager builder is nil")
+	}
+	if _, dup := managers[name]; dup {
+		panic("rollingwriter: register manager called twice for " + name)
+	}
+	managers[name] = builder
 }
 
 // NewManager generate the Manager with config
+// The ManagerBuilder will be used if given, then the manager registered as
+// RollingManager, otherwise the build-in manager with RollingPolicy
 func NewManager(c *Config) (Manager, error) {
+	if c.ManagerBuilder != nil {
+		return c.ManagerBuilder(c)
+	}
+	if c.RollingManager != "" {
+		managersLock.RLock()
+		builder, ok := managers[c.RollingManager]
+		managersLock.RUnlock()
+		if !ok {
+			return nil, ErrUnknownManager
+		}
+		return builder(c)
+	}
+
 	m := &manager{
-		startAt: time.Now(),
-		cr:      cron.New(),
-		fire:    make(chan string),
-		context: make(chan int),
-		wg:      sync.WaitGroup{},
+		startAt:   time.Now(),
+		cr:        cron.New(),
+		fire:      make(chan string),
+		context:   make(chan int),
+		precision: c.precision(),
 	}
 
 	// start the manager according to policy
@@ -35,44 +80,118 @@ func NewManager(c *Config) (Manager, error) {
 	default:
 		fallthrough
 	case WithoutRolling:
-		return m, nil
 	case TimeRolling:
-		if err := m.cr.AddFunc(c.RollingTimePattern, func() {
-			m.fire <- m.GenLogFileName(c)
-		}); err != nil {
+		if err := m.startTimeRolling(c); err != nil {
 			return nil, err
 		}
-		m.cr.Start()
 	case VolumeRolling:
+		// the writer accounts the file size and do the rolling itself
 		m.ParseVolume(c)
-		m.wg.Add(1)
-		go func() {
-			timer := time.NewTicker(time.Duration(Precision) * time.Second)
-			defer timer.Stop()
+	case TimeAndVolumeRolling:
+		m.combined = true
+		m.ParseVolume(c)
+		if err := m.startTimeRolling(c); err != nil {
+			return nil, err
+		}
+	}
+
+	// start after the policy, nothing to stop if the policy is invalid
+	if len(c.ReopenSignals) > 0 {
+		m.startReopenOnSignal(c.ReopenSignals)
+	}
+	return m, nil
+}
+
+// startTimeRolling fire the rolling event with the cron table
+func (m *manager) startTimeRolling(c *Config) error {
+	if err := m.cr.AddFunc(c.RollingTimePattern, func() {
+		m.rolling(c, TriggerTime)
+	}); err != nil {
+		return err
+	}
+	m.cr.Start()
+	return nil
+}
+
+// startReopenOnSignal fire the reopen event when receive the signals
+func (m *manager) startReopenOnSignal(sigs []os.Signal) {
+	m.signals = make(chan os.Signal, 1)
+	signal.Notify(m.signals, sigs...)
+	go func() {
+		for {
+			select {
+			case <-m.context:
+				return
+			case <-m.signals:
+				select {
+				case m.fire <- "":
+				case <-m.context:
+					return
+				}
+			}
+		}
+	}()
+}
 
-			filepath := LogFilePath(c)
-			var file *os.File
-			var err error
-			m.wg.Done()
+// startWatchdog check the log file every Precision seconds, fire the reopen event
+// once the file opened by the writer is not the one on the path and report ErrFileMoved
+func (m *manager) startWatchdog(c *Config, moved func() bool, report func(error)) {
+	go func() {
+		timer := time.NewTicker(m.precision)
+		defer timer.Stop()
 
-			for {
+		filepath := LogFilePath(c)
+		for {
+			select {
+			case <-m.context:
+				return
+			case <-timer.C:
+				if !moved() {
+					continue
+				}
+
+				report(fmt.Errorf("%w: %s", ErrFileMoved, filepath))
 				select {
+				case m.fire <- "":
 				case <-m.context:
 					return
-				case <-timer.C:
-					if file, err = os.Open(filepath); err != nil {
-						continue
-					}
-					if info, err := file.Stat(); err == nil && info.Size() > m.thresholdSize {
-						m.fire <- m.GenLogFileName(c)
-					}
-					file.Close()
 				}
 			}
-		}()
-		m.wg.Wait()
+		}
+	}()
+}
+
+// rolling send the new file name to the writer. Only one event will be in flight,
+// the triggers raised meanwhile are dropped. For the combined policy, triggers
+// raised within Precision seconds after the last rolling are dropped as well
+func (m *manager) rolling(c *Config, trigger string) {
+	if !atomic.CompareAndSwapInt32(&m.firing, 0, 1) {
+		return
 	}
-	return m, nil
+	defer atomic.StoreInt32(&m.firing, 0)
+
+	if m.combined {
+		m.lock.Lock()
+		last := m.startAt
+		m.lock.Unlock()
+		if time.Since(last) < m.precision {
+			return
+		}
+	}
+
+	select {
+	case m.fire <- m.rollingFileName(c, trigger):
+	case <-m.context:
+	}
+}
+
+// rollingFileName generate the new log file name for the trigger,
+// only the combined policy tag the trigger into the name
+func (m *manager) rollingFileName(c *Config, trigger string) string {
+	if !m.combined {
+		trigger = ""
+	}
+	return m.genLogFileName(c, trigger)
 }
 
 // Fire return the fire channel
@@ -82,21 +201,28 @@ func (m *manager) Fire() chan string {
 
 // Close return stop the manager and return
 func (m *manager) Close() {
+	if m.signals != nil {
+		signal.Stop(m.signals)
+	}
 	close(m.context)
 	m.cr.Stop()
 }
 
 // ParseVolume parse the config volume format and return threshold
 func (m *manager) ParseVolume(c *Config) {
-	s := []byte(strings.ToUpper(c.RollingVolumeSize))
+	m.thresholdSize = parseVolume(c.RollingVolumeSize)
+}
+
+// parseVolume parse the volume format like 1G, 500MB, 1GB by default
+func parseVolume(volume string) int64 {
+	s := []byte(strings.ToUpper(volume))
 	if !(strings.Contains(string(s), "K") || strings.Contains(string(s), "KB") ||
 		strings.Contains(string(s), "M") || strings.Contains(string(s), "MB") ||
 		strings.Contains(string(s), "G") || strings.Contains(string(s), "GB") ||
 		strings.Contains(string(s), "T") || strings.Contains(string(s), "TB")) {
 
 		// set the default threshold with 1GB
-		m.thresholdSize = 1024 * 1024 * 1024
-		return
+		return 1024 * 1024 * 1024
 	}
 
 	var unit int64 = 1
@@ -123,11 +249,45 @@ func (m *manager) ParseVolume(c *Config) {
 	case "K", "KB":
 		unit *= 1024
 	}
-	m.thresholdSize = int64(p) * unit
+	return int64(p) * unit
+}
+
+// parseSize parse the size like 4096, 4k, 500MB strictly, the size without unit is
+// in bytes. Return ErrInvalidArgument if the format is invalid or the size is not positive
+func parseSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	var unit int64 = 1
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		case 'T':
+			unit = 1 << 40
+		}
+		if unit > 1 {
+			s = s[:n-1]
+		}
+	}
+
+	p, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || p <= 0 || p > math.MaxInt64/unit {
+		return 0, ErrInvalidArgument
+	}
+	return p * unit, nil
 }
 
 // GenLogFileName generate the new log file name, filename should be absolute path
 func (m *manager) GenLogFileName(c *Config) (filename string) {
+	return m.genLogFileName(c, "")
+}
+
+// genLogFileName generate the new log file name, the trigger will be tagged
+// into the name if it is not empty
+func (m *manager) genLogFileName(c *Config, trigger string) (filename string) {
 	// if fileextention is not set, use the default value
 	// this line is added to provide backwards compatibility with the current code and unit tests
 	// in the next major release, this line should be removed.
@@ -136,7 +296,7 @@ func (m *manager) GenLogFileName(c *Config) (filename string) {
 	}
 
 	m.lock.Lock()
-	filename = c.fileFormat(m.startAt)
+	filename = c.fileFormat(m.startAt, trigger)
 	// reset the start time to now
 	m.startAt = time.Now()
 	m.lock.Unlock()
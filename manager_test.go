@@ -1,7 +1,12 @@
 package rollingwriter
 
 import (
+	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"runtime"
+	"syscall"
 	"testing"
 	"time"
 
@@ -57,6 +62,19 @@ func TestParseVolume(t *testing.T) {
 	assert.Equal(t, int64(1024*1024*1024*1024), m.thresholdSize)
 }
 
+func TestParseSize(t *testing.T) {
+	for size, expect := range map[string]int64{"4096": 4096, "100b": 100, "4k": 4096, "4KB": 4096,
+		"2mb": 2 << 20, " 1G ": 1 << 30, "1tB": 1 << 40} {
+		n, err := parseSize(size)
+		assert.Nil(t, err)
+		assert.Equal(t, expect, n, size)
+	}
+	for _, size := range []string{"", "kb", "abc", "1.5mb", "0", "-1k", "1xb", "9999999999t"} {
+		_, err := parseSize(size)
+		assert.Equal(t, ErrInvalidArgument, err, size)
+	}
+}
+
 func TestGenLogFileName(t *testing.T) {
 	m := manager{}
 	c := &Config{
@@ -75,3 +93,117 @@ func TestGenLogFileName(t *testing.T) {
 	timetag = m.startAt.Format(c.TimeTagFormat)
 	assert.Equal(t, path.Join("./", "file"+".log.gz."+timetag), dest)
 }
+
+func TestGenLogFileNameWithTrigger(t *testing.T) {
+	m := manager{}
+	c := &Config{
+		LogPath:       "./",
+		FileName:      "file",
+		TimeTagFormat: "200601021504",
+	}
+	m.startAt = time.Now()
+
+	timetag := m.startAt.Format(c.TimeTagFormat)
+	dest := m.genLogFileName(c, TriggerVolume)
+	assert.Equal(t, path.Join("./", "file"+".log.volume."+timetag), dest)
+
+	c.FileFormatter = func(time.Time) string { return "./file.log" }
+	dest = m.genLogFileName(c, TriggerTime)
+	assert.Equal(t, "./file.log.time", dest)
+}
+
+func TestTimeAndVolumeRolling(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(WithLogPath(dir), WithFileName("file"), WithLock(),
+		WithRollingTimeAndVolume("0 0 0 * * *", "1k"))
+	assert.Nil(t, err)
+	defer w.Close()
+
+	w.Write(make([]byte, 2048))
+	files, _ := filepath.Glob(path.Join(dir, "file.log.volume.*"))
+	assert.Equal(t, 1, len(files))
+
+	// the time trigger fired right after the volume trigger should be merged
+	m := w.(*LockedWriter).m.(*manager)
+	go m.rolling(w.(*LockedWriter).cf, TriggerTime)
+	select {
+	case name := <-m.Fire():
+		t.Fatal("trigger should be merged", name)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+type chanManager struct {
+	fire chan string
+}
+
+func (m *chanManager) Fire() chan string { return m.fire }
+func (m *chanManager) Close()            {}
+
+func TestRegisterManager(t *testing.T) {
+	mng := &chanManager{fire: make(chan string, 1)}
+	name := "test-chan-" + time.Now().Format(time.RFC3339Nano)
+	RegisterManager(name, func(*Config) (Manager, error) { return mng, nil })
+	assert.Panics(t, func() {
+		RegisterManager(name, func(*Config) (Manager, error) { return mng, nil })
+	})
+
+	_, err := NewWriter(WithLogPath(t.TempDir()), WithRollingManager("test-not-exist"))
+	assert.Equal(t, ErrUnknownManager, err)
+
+	dir := t.TempDir()
+	w, err := NewWriter(WithLogPath(dir), WithFileName("custom"), WithRollingManager(name))
+	assert.Nil(t, err)
+	defer w.Close()
+
+	c := &Config{LogPath: dir, FileName: "custom", FileExtension: "log", TimeTagFormat: "200601021504"}
+	backup := BackupFilePath(c, time.Now())
+	w.Write([]byte("before rolling\n"))
+	mng.fire <- backup
+	w.Write([]byte("after rolling\n"))
+
+	b, err := os.ReadFile(backup)
+	assert.Nil(t, err)
+	assert.Equal(t, "before rolling\n", string(b))
+}
+
+func TestCustomManagerRotate(t *testing.T) {
+	dir := t.TempDir()
+	rotated := make(chan RotateEvent, 2)
+	w, err := NewWriter(WithLogPath(dir), WithFileName("custom"), WithTimeTagFormat("20060102150405,000000000"),
+		WithManager(func(*Config) (Manager, error) { return &chanManager{fire: make(chan string)}, nil }),
+		WithOnRotate(func(event RotateEvent) { rotated <- event }))
+	assert.Nil(t, err)
+
+	// the rolling file is named by the time it opened
+	for i := 0; i < 2; i++ {
+		w.Write([]byte("rotate manually\n"))
+		time.Sleep(time.Millisecond)
+		assert.Nil(t, w.Rotate())
+	}
+	assert.Nil(t, w.Close())
+
+	first, second := <-rotated, <-rotated
+	if second.Start.Before(first.Start) {
+		first, second = second, first
+	}
+	cfg := w.(*LockedWriter).cf
+	for _, event := range []RotateEvent{first, second} {
+		assert.Equal(t, BackupFilePath(cfg, event.Start), event.FinalPath)
+	}
+	assert.Equal(t, first.End, second.Start)
+}
+
+func TestNewManagerSignalLeak(t *testing.T) {
+	// start the signal watcher of the runtime before counting
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	signal.Stop(ch)
+
+	before := runtime.NumGoroutine()
+	_, err := NewManager(&Config{RollingPolicy: TimeRolling, RollingTimePattern: "invalid",
+		ReopenSignals: []os.Signal{syscall.SIGHUP}})
+	assert.NotNil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, runtime.NumGoroutine() <= before)
+}
@@ -0,0 +1,96 @@
+package rollingwriter
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics receive the measurements of the writer, the implementation should be
+// safe for concurrent use and must not block
+type Metrics interface {
+	// BytesWritten count the bytes written into the log file
+	BytesWritten(n int)
+	// Rotated count the rotation with its trigger, e.g. TriggerTime
+	Rotated(trigger string)
+	// Compressed observe the duration of the compression and its result
+	Compressed(d time.Duration, err error)
+	// QueueDepth set the number of writes queued in the asynchronous writer
+	QueueDepth(n int)
+	// Dropped count the write dropped by the asynchronous writer with ErrQueueFull
+	Dropped()
+	// Flushed count the flush of the buffer writer with the bytes flushed
+	Flushed(n int)
+}
+
+// nopMetrics discard all the measurements
+type nopMetrics struct{}
+
+func (nopMetrics) BytesWritten(int)                {}
+func (nopMetrics) Rotated(string)                  {}
+func (nopMetrics) Compressed(time.Duration, error) {}
+func (nopMetrics) QueueDepth(int)                  {}
+func (nopMetrics) Dropped()                        {}
+func (nopMetrics) Flushed(int)                     {}
+
+// metrics return the metrics configured, discard the measurements if not set
+func (c *Config) metrics() Metrics {
+	if c.Metrics != nil {
+		return c.Metrics
+	}
+	return nopMetrics{}
+}
+
+// ExpvarMetrics publish the measurements as an expvar map, served on /debug/vars
+// with the http.DefaultServeMux
+type ExpvarMetrics struct {
+	vars            *expvar.Map
+	rotations       *expvar.Map
+	compressSeconds *expvar.Float
+	queueDepth      *expvar.Int
+}
+
+// NewExpvarMetrics publish the expvar map with the name, the names should be
+// unique in process as the expvar.Publish, otherwise it panics
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		vars:            expvar.NewMap(name),
+		rotations:       new(expvar.Map).Init(),
+		compressSeconds: new(expvar.Float),
+		queueDepth:      new(expvar.Int),
+	}
+	m.vars.Set("rotations", m.rotations)
+	m.vars.Set("compress_seconds", m.compressSeconds)
+	m.vars.Set("queue_depth", m.queueDepth)
+	for _, key := range []string{"bytes_written", "compressions", "compress_errors", "dropped", "flushes", "flushed_bytes"} {
+		m.vars.Add(key, 0)
+	}
+	return m
+}
+
+// BytesWritten add n to bytes_written
+func (m *ExpvarMetrics) BytesWritten(n int) { m.vars.Add("bytes_written", int64(n)) }
+
+// Rotated add 1 to rotations of the trigger
+func (m *ExpvarMetrics) Rotated(trigger string) { m.rotations.Add(trigger, 1) }
+
+// Compressed add 1 to compressions or compress_errors and the duration to compress_seconds
+func (m *ExpvarMetrics) Compressed(d time.Duration, err error) {
+	if err != nil {
+		m.vars.Add("compress_errors", 1)
+	} else {
+		m.vars.Add("compressions", 1)
+	}
+	m.compressSeconds.Add(d.Seconds())
+}
+
+// QueueDepth set queue_depth to n
+func (m *ExpvarMetrics) QueueDepth(n int) { m.queueDepth.Set(int64(n)) }
+
+// Dropped add 1 to dropped
+func (m *ExpvarMetrics) Dropped() { m.vars.Add("dropped", 1) }
+
+// Flushed add 1 to flushes and n to flushed_bytes
+func (m *ExpvarMetrics) Flushed(n int) {
+	m.vars.Add("flushes", 1)
+	m.vars.Add("flushed_bytes", int64(n))
+}
@@ -0,0 +1,54 @@
+package rollingwriter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpvarMetrics(t *testing.T) {
+	m := NewExpvarMetrics("rollingwriter_test_" + time.Now().Format("150405.000000000"))
+	w, err := NewWriter(WithLogPath(t.TempDir()), WithFileName("metrics"), WithoutRollingPolicy(),
+		WithBuffer(), WithCompress(), WithMetrics(m))
+	assert.Nil(t, err)
+
+	data := []byte("measure the writer\n")
+	w.Write(data)
+	assert.Nil(t, w.Rotate())
+	assert.Nil(t, w.Close())
+
+	var vars struct {
+		BytesWritten   int64            `json:"bytes_written"`
+		Rotations      map[string]int64 `json:"rotations"`
+		Compressions   int64            `json:"compressions"`
+		CompressErrors int64            `json:"compress_errors"`
+		Flushes        int64            `json:"flushes"`
+		FlushedBytes   int64            `json:"flushed_bytes"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(m.vars.String()), &vars))
+	assert.Equal(t, int64(len(data)), vars.BytesWritten)
+	assert.Equal(t, map[string]int64{TriggerManual: 1}, vars.Rotations)
+	assert.Equal(t, int64(1), vars.Compressions)
+	assert.Equal(t, int64(0), vars.CompressErrors)
+	assert.Equal(t, int64(1), vars.Flushes)
+	assert.Equal(t, int64(len(data)), vars.FlushedBytes)
+}
+
+func TestQueueMetrics(t *testing.T) {
+	m := NewExpvarMetrics("rollingwriter_test_" + time.Now().Format("150405.000000000"))
+	w, err := NewWriter(WithLogPath(t.TempDir()), WithFileName("queue"), WithoutRollingPolicy(),
+		WithAsynchronous(), WithMetrics(m))
+	assert.Nil(t, err)
+
+	var dropped int64
+	for i := 0; i < 2*QueueSize; i++ {
+		if _, err := w.Write([]byte("fill the queue\n")); err == ErrQueueFull {
+			dropped++
+		}
+	}
+	assert.Nil(t, w.Close())
+	assert.Equal(t, dropped, m.vars.Get("dropped").(interface{ Value() int64 }).Value())
+	assert.Equal(t, int64(0), m.queueDepth.Value())
+}
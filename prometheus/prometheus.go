@@ -0,0 +1,119 @@
+// Package prometheus export the rollingwriter metrics as the prometheus collector.
+// It is a separate module, so the rollingwriter does not depend on the prometheus client
+//
+//	m := prometheus.NewMetrics("app", prometheus.Labels{"log": "access"})
+//	prom.MustRegister(m)
+//	w, err := rollingwriter.NewWriter(rollingwriter.WithMetrics(m))
+package prometheus
+
+import (
+	"time"
+
+	"github.com/arthurkiller/rollingwriter"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels are the constant labels attached to all the metrics
+type Labels = prom.Labels
+
+// Metrics implement both rollingwriter.Metrics and prometheus.Collector
+type Metrics struct {
+	bytesWritten    prom.Counter
+	rotations       *prom.CounterVec
+	compressions    *prom.CounterVec
+	compressSeconds prom.Histogram
+	queueDepth      prom.Gauge
+	dropped         prom.Counter
+	flushes         prom.Counter
+	flushedBytes    prom.Counter
+}
+
+var _ rollingwriter.Metrics = (*Metrics)(nil)
+
+// NewMetrics return the metrics named in namespace_rollingwriter_*, register it
+// to the prometheus registry before use
+func NewMetrics(namespace string, labels Labels) *Metrics {
+	const subsystem = "rollingwriter"
+	return &Metrics{
+		bytesWritten: prom.NewCounter(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, ConstLabels: labels,
+			Name: "written_bytes_total", Help: "Bytes written into the log file.",
+		}),
+		rotations: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, ConstLabels: labels,
+			Name: "rotations_total", Help: "Rotations of the log file by trigger.",
+		}, []string{"trigger"}),
+		compressions: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, ConstLabels: labels,
+			Name: "compressions_total", Help: "Compressions of the rolling file by result.",
+		}, []string{"result"}),
+		compressSeconds: prom.NewHistogram(prom.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem, ConstLabels: labels,
+			Name: "compress_duration_seconds", Help: "Duration of the rolling file compression.",
+			Buckets: prom.ExponentialBuckets(0.01, 4, 8),
+		}),
+		queueDepth: prom.NewGauge(prom.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, ConstLabels: labels,
+			Name: "queue_depth", Help: "Writes queued in the asynchronous writer.",
+		}),
+		dropped: prom.NewCounter(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, ConstLabels: labels,
+			Name: "dropped_writes_total", Help: "Writes dropped by the asynchronous writer with the queue full.",
+		}),
+		flushes: prom.NewCounter(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, ConstLabels: labels,
+			Name: "flushes_total", Help: "Flushes of the buffer writer.",
+		}),
+		flushedBytes: prom.NewCounter(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, ConstLabels: labels,
+			Name: "flushed_bytes_total", Help: "Bytes flushed by the buffer writer.",
+		}),
+	}
+}
+
+func (m *Metrics) collectors() []prom.Collector {
+	return []prom.Collector{m.bytesWritten, m.rotations, m.compressions, m.compressSeconds,
+		m.queueDepth, m.dropped, m.flushes, m.flushedBytes}
+}
+
+// Describe implement the prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implement the prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// BytesWritten implement the rollingwriter.Metrics
+func (m *Metrics) BytesWritten(n int) { m.bytesWritten.Add(float64(n)) }
+
+// Rotated implement the rollingwriter.Metrics
+func (m *Metrics) Rotated(trigger string) { m.rotations.WithLabelValues(trigger).Inc() }
+
+// Compressed implement the rollingwriter.Metrics
+func (m *Metrics) Compressed(d time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.compressions.WithLabelValues(result).Inc()
+	m.compressSeconds.Observe(d.Seconds())
+}
+
+// QueueDepth implement the rollingwriter.Metrics
+func (m *Metrics) QueueDepth(n int) { m.queueDepth.Set(float64(n)) }
+
+// Dropped implement the rollingwriter.Metrics
+func (m *Metrics) Dropped() { m.dropped.Inc() }
+
+// Flushed implement the rollingwriter.Metrics
+func (m *Metrics) Flushed(n int) {
+	m.flushes.Inc()
+	m.flushedBytes.Add(float64(n))
+}
@@ -0,0 +1,35 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arthurkiller/rollingwriter"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics("test", Labels{"log": "metrics"})
+	reg := prom.NewPedanticRegistry()
+	assert.Nil(t, reg.Register(m))
+
+	w, err := rollingwriter.NewWriter(rollingwriter.WithLogPath(t.TempDir()), rollingwriter.WithFileName("metrics"),
+		rollingwriter.WithoutRollingPolicy(), rollingwriter.WithLock(), rollingwriter.WithMetrics(m))
+	assert.Nil(t, err)
+	w.Write([]byte("export the metrics\n"))
+	assert.Nil(t, w.Rotate())
+	assert.Nil(t, w.Close())
+
+	expected := `
+# HELP test_rollingwriter_rotations_total Rotations of the log file by trigger.
+# TYPE test_rollingwriter_rotations_total counter
+test_rollingwriter_rotations_total{log="metrics",trigger="manual"} 1
+# HELP test_rollingwriter_written_bytes_total Bytes written into the log file.
+# TYPE test_rollingwriter_written_bytes_total counter
+test_rollingwriter_written_bytes_total{log="metrics"} 19
+`
+	assert.Nil(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"test_rollingwriter_rotations_total", "test_rollingwriter_written_bytes_total"))
+}
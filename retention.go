@@ -0,0 +1,273 @@
+package rollingwriter
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retention keeps the rolling files and clean them up by MaxRemain, MaxAge and MaxTotalSize.
+// The files are discovered by the default naming pattern in LogPath and the manifest file
+// recording every file rolled, so the files named by FileFormatter, compressed or left
+// with .tmp suffix by crash are tracked across restarts
+//
+// the manifest file is located here:
+//
+//	[LogPath]/.[FileName].[FileExtension].manifest
+type retention struct {
+	cf           *Config
+	manifest     string
+	maxTotalSize int64
+	report       func(error)
+
+	lock  sync.Mutex
+	files []string // the oldest first
+	// pending is the files still compressing, they are not cleaned up until done
+	pending map[string]bool
+}
+
+// newRetention load the rolling files and clean them up,
+// the errors occurred later are reported to report
+func newRetention(c *Config, report func(error)) (*retention, error) {
+	r := &retention{
+		cf:       c,
+		manifest: manifestPath(c),
+		report:   report,
+		pending:  make(map[string]bool),
+	}
+	if c.MaxTotalSize != "" {
+		r.maxTotalSize, _ = parseSize(c.MaxTotalSize)
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.clean()
+	return r, r.save()
+}
+
+// load discover the rolling files from manifest and LogPath
+func (r *retention) load() error {
+	manifest, err := readManifest(r.cf)
+	if err != nil {
+		return err
+	}
+	candidates := make([]string, 0, 2*len(manifest))
+	for _, name := range manifest {
+		candidates = append(candidates, name, name+".tmp")
+	}
+
+	dir, err := os.ReadDir(r.cf.LogPath)
+	if err != nil {
+		return err
+	}
+	for _, fi := range dir {
+		if !fi.IsDir() && r.cf.isBackup(fi.Name()) {
+			candidates = append(candidates, path.Join(r.cf.LogPath, fi.Name()))
+		}
+	}
+
+	seen := make(map[string]bool, len(candidates))
+	modTime := make(map[string]time.Time, len(candidates))
+	for _, file := range candidates {
+		file = filepath.Clean(file)
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			r.files = append(r.files, file)
+			modTime[file] = info.ModTime()
+		}
+	}
+	// keep the manifest order for the files modified at the same time
+	sort.SliceStable(r.files, func(i, j int) bool {
+		return modTime[r.files[i]].Before(modTime[r.files[j]])
+	})
+	return nil
+}
+
+// manifestPath return the manifest file path
+func manifestPath(c *Config) string {
+	return path.Join(c.LogPath, "."+c.FileName+"."+c.FileExtension+".manifest")
+}
+
+// readManifest return the rolling files recorded in manifest
+func readManifest(c *Config) ([]string, error) {
+	file, err := os.Open(manifestPath(c))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	files := make([]string, 0, 10)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			files = append(files, name)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// isBackup report whether the file name is a rolling file with the default naming
+//
+//	[FileName].[FileExtension].[...].[TimeTag][.Seq][.tmp]
+func (c *Config) isBackup(name string) bool {
+	prefix := c.FileName + "." + c.FileExtension + "."
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	name = strings.TrimSuffix(name, ".tmp")
+	if len(name) <= len(prefix) {
+		return false
+	}
+	tag := name[strings.LastIndex(name, ".")+1:]
+	if _, err := time.Parse(c.TimeTagFormat, tag); err == nil {
+		return true
+	}
+	// the sequence appended to the time tag by freeBackupName
+	if _, err := strconv.Atoi(tag); err != nil {
+		return false
+	}
+	name = strings.TrimSuffix(name, "."+tag)
+	if len(name) <= len(prefix) {
+		return false
+	}
+	_, err := time.Parse(c.TimeTagFormat, name[strings.LastIndex(name, ".")+1:])
+	return err == nil
+}
+
+// add track the new rolling file as the newest one, the file still compressing is
+// pending until done
+func (r *retention) add(file string, pending bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	file = filepath.Clean(file)
+	if pending {
+		r.pending[file] = true
+	}
+	for i, f := range r.files {
+		if f == file {
+			r.files = append(r.files[:i], r.files[i+1:]...)
+			break
+		}
+	}
+	r.files = append(r.files, file)
+	if err := r.save(); err != nil {
+		r.report(&RetentionError{Op: "save", Path: r.manifest, Err: err})
+	}
+}
+
+// done finish the rolling of file and clean up the rolling files. The final file takes
+// the place of file, it is the backup left if the compression failed
+func (r *retention) done(file, final string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	file = filepath.Clean(file)
+	delete(r.pending, file)
+	for i, f := range r.files {
+		if f == file {
+			r.files[i] = filepath.Clean(final)
+			break
+		}
+	}
+	r.clean()
+	if err := r.save(); err != nil {
+		r.report(&RetentionError{Op: "save", Path: r.manifest, Err: err})
+	}
+}
+
+// removeOldest delete the oldest rolling file
+func (r *retention) removeOldest() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.files) > 0 {
+		r.files = r.remove(r.files, 1)
+		if err := r.save(); err != nil {
+			r.report(&RetentionError{Op: "save", Path: r.manifest, Err: err})
+		}
+	}
+}
+
+// clean delete the rolling files exceed MaxAge, MaxTotalSize or MaxRemain
+func (r *retention) clean() {
+	if r.cf.MaxAge > 0 {
+		n := 0
+		for _, file := range r.files {
+			info, err := r.stat(file)
+			if err == nil && time.Since(info.ModTime()) <= r.cf.MaxAge {
+				break
+			}
+			n++
+		}
+		r.files = r.remove(r.files, n)
+	}
+
+	if r.maxTotalSize > 0 {
+		var total int64
+		sizes := make([]int64, len(r.files))
+		for i, file := range r.files {
+			if info, err := r.stat(file); err == nil {
+				sizes[i] = info.Size()
+				total += sizes[i]
+			}
+		}
+		n := 0
+		for ; n < len(r.files) && total > r.maxTotalSize; n++ {
+			total -= sizes[n]
+		}
+		r.files = r.remove(r.files, n)
+	}
+
+	if r.cf.MaxRemain > 0 && len(r.files) > r.cf.MaxRemain {
+		r.files = r.remove(r.files, len(r.files)-r.cf.MaxRemain)
+	}
+}
+
+// stat return the file info of the rolling file, or its tempfile if still compressing
+func (r *retention) stat(file string) (os.FileInfo, error) {
+	if r.pending[file] {
+		file += ".tmp"
+	}
+	return os.Stat(file)
+}
+
+// remove delete the first n files and return the remaining,
+// the files pending or failed to delete will be kept
+func (r *retention) remove(files []string, n int) []string {
+	remain := make([]string, 0, len(files))
+	for _, file := range files[:n] {
+		if r.pending[file] {
+			remain = append(remain, file)
+			continue
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			r.report(&RetentionError{Op: "remove", Path: file, Err: err})
+			remain = append(remain, file)
+		}
+	}
+	return append(remain, files[n:]...)
+}
+
+// save write the manifest atomically
+func (r *retention) save() error {
+	tmp := r.manifest + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(r.files, "\n")), DefaultFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.manifest)
+}
@@ -0,0 +1,127 @@
+package rollingwriter
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBackup(t *testing.T) {
+	c := &Config{FileName: "file", FileExtension: "log", TimeTagFormat: "200601021504"}
+
+	assert.True(t, c.isBackup("file.log.202601021504"))
+	assert.True(t, c.isBackup("file.log.gz.202601021504"))
+	assert.True(t, c.isBackup("file.log.volume.202601021504"))
+	assert.True(t, c.isBackup("file.log.gz.202601021504.tmp"))
+	assert.True(t, c.isBackup("file.log.202601021504.2"))
+	assert.True(t, c.isBackup("file.log.gz.202601021504.2.tmp"))
+	assert.False(t, c.isBackup("file.log.2"))
+	assert.False(t, c.isBackup("file.log"))
+	assert.False(t, c.isBackup("file.log.tmp"))
+	assert.False(t, c.isBackup("file.log.gz"))
+	assert.False(t, c.isBackup("other.log.202601021504"))
+	assert.False(t, c.isBackup(".file.log.manifest"))
+}
+
+func TestRetentionDiscover(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"file.log.202601021501",
+		"file.log.gz.202601021502",
+		"file.log.volume.202601021503",
+		"file.log.gz.202601021504.tmp",
+	}
+	for i, file := range files {
+		file = path.Join(dir, file)
+		files[i] = file
+		assert.Nil(t, os.WriteFile(file, []byte("backup\n"), 0644))
+		mod := time.Now().Add(time.Duration(i-len(files)) * time.Minute)
+		assert.Nil(t, os.Chtimes(file, mod, mod))
+	}
+
+	c := &Config{LogPath: dir, FileName: "file", FileExtension: "log", TimeTagFormat: "200601021504", MaxRemain: 10}
+	r, err := newRetention(c, func(err error) { t.Error(err) })
+	assert.Nil(t, err)
+	assert.Equal(t, files, r.files)
+
+	c.MaxRemain = 2
+	r.removeOldest()
+	r.add(files[2], false)
+	r.done(files[2], files[2])
+	assert.Equal(t, []string{files[3], files[2]}, r.files)
+	_, err = os.Stat(files[1])
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRetentionPending(t *testing.T) {
+	dir := t.TempDir()
+	c := &Config{LogPath: dir, FileName: "file", FileExtension: "log", TimeTagFormat: "200601021504", MaxRemain: 1}
+	r, err := newRetention(c, func(err error) { t.Error(err) })
+	assert.Nil(t, err)
+
+	files := []string{
+		path.Join(dir, "file.log.gz.202601021501"),
+		path.Join(dir, "file.log.gz.202601021502"),
+		path.Join(dir, "file.log.gz.202601021503"),
+	}
+	// the oldest one is still compressing when the newer ones are done
+	assert.Nil(t, os.WriteFile(files[0]+".tmp", []byte("backup\n"), 0644))
+	r.add(files[0], true)
+	for _, file := range files[1:] {
+		assert.Nil(t, os.WriteFile(file, []byte("backup\n"), 0644))
+		r.add(file, true)
+		r.done(file, file)
+	}
+	assert.Equal(t, []string{files[0], files[2]}, r.files)
+	_, err = os.Stat(files[0] + ".tmp")
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.Rename(files[0]+".tmp", files[0]))
+	r.done(files[0], files[0])
+	assert.Equal(t, []string{files[2]}, r.files)
+	backups, _ := filepath.Glob(path.Join(dir, "file.log.*"))
+	assert.Equal(t, []string{files[2]}, backups)
+	manifest, err := readManifest(c)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{files[2]}, manifest)
+}
+
+func TestRetentionManifest(t *testing.T) {
+	dir := t.TempDir()
+	var count int
+	formatter := func(time.Time) string {
+		count++
+		return path.Join(dir, "custom-"+string(rune('a'+count))+".log")
+	}
+
+	w, err := NewWriter(WithLogPath(dir), WithFileName("file"), WithLock(),
+		WithFileFormatter(formatter), WithMaxRemain(3))
+	assert.Nil(t, err)
+	for i := 0; i < 4; i++ {
+		w.Write([]byte("custom\n"))
+		assert.Nil(t, w.Rotate())
+	}
+	w.Close()
+
+	var backups []string
+	for i := 0; i < 100; i++ {
+		backups, _ = filepath.Glob(path.Join(dir, "custom-*.log"))
+		if len(backups) == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 3, len(backups))
+
+	// the files named by formatter are found by the manifest after restart
+	w, err = NewWriter(WithLogPath(dir), WithFileName("file"), WithLock(),
+		WithFileFormatter(formatter), WithMaxRemain(1))
+	assert.Nil(t, err)
+	w.Close()
+	backups, _ = filepath.Glob(path.Join(dir, "custom-*.log"))
+	assert.Equal(t, []string{path.Join(dir, "custom-e.log")}, backups)
+}
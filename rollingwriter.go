@@ -1,6 +1,8 @@
 package rollingwriter
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"os"
@@ -9,20 +11,59 @@ import (
 	"time"
 )
 
-// RollingPolicies giveout 3 policy for rolling.
+// RollingPolicies giveout 4 policy for rolling.
 const (
 	WithoutRolling = iota
 	TimeRolling
 	VolumeRolling
+	TimeAndVolumeRolling
+)
+
+// Rolling triggers, TimeAndVolumeRolling tag the trigger into the truncated file name
+const (
+	TriggerTime   = "time"
+	TriggerVolume = "volume"
+	TriggerManual = "manual"
+	// TriggerManager is the rolling fired by the custom manager
+	TriggerManager = "manager"
+)
+
+// Sync policies fsync the log file for durability, all but SyncPolicyNever sync the file
+// on rotation and close, and the directory after the file renamed
+const (
+	// SyncPolicyNever leave the fsync to the os, by default
+	SyncPolicyNever = "never"
+	// SyncPolicyWrite sync after every write into the file
+	SyncPolicyWrite = "write"
+	// SyncPolicyBytes sync after every SyncBytes written
+	SyncPolicyBytes = "bytes"
+	// SyncPolicyInterval sync every SyncInterval
+	SyncPolicyInterval = "interval"
+	// SyncPolicyRotate sync on rotation and close only
+	SyncPolicyRotate = "rotate"
+)
+
+// Overflow policies of the asynchronous writer when the queue is full
+const (
+	// OverflowDropNewest drop the write with ErrQueueFull, by default
+	OverflowDropNewest = "drop_newest"
+	// OverflowBlock block the write until the queue has room or QueueBlockTimeout
+	OverflowBlock = "block"
+	// OverflowDropOldest drop the oldest write in queue for the new one
+	OverflowDropOldest = "drop_oldest"
+	// OverflowSample block one write in every QueueSampleRate writes and drop the others
+	OverflowSample = "sample"
 )
 
 var (
-	// BufferSize defined the buffer size, by default 1 KB buffer will be allocated
+	// BufferSize defined the buffer size, by default 1 KB buffer will be allocated.
+	// It is the default of Config.BufferSize
 	BufferSize = 1024
-	// QueueSize defined the queue size for asynchronize write
+	// QueueSize defined the queue size for asynchronize write.
+	// It is the default of Config.QueueSize
 	QueueSize = 1024
 	// Precision defined the precision about the reopen operation condition
-	// check duration within second
+	// check duration within second. It is the default of Config.Precision
 	Precision = 1
 	// DefaultFileMode set the default open mode rw-r--r-- by default
 	DefaultFileMode = os.FileMode(0644)
@@ -37,21 +78,63 @@ var (
 	ErrInvalidArgument = errors.New("error argument invalid")
 	// ErrQueueFull defined the queue full
 	ErrQueueFull = errors.New("async log queue full")
+	// ErrUnknownManager defined the rolling manager not registered
+	ErrUnknownManager = errors.New("error rolling manager not registered")
+	// ErrUnknownCompressor defined the compressor not registered
+	ErrUnknownCompressor = errors.New("error compressor not registered")
+	// ErrCloseTimeout defined the background jobs not finished on close
+	ErrCloseTimeout = errors.New("error background jobs not finished on close")
+	// ErrFileMoved defined the log file moved or deleted by others
+	ErrFileMoved = errors.New("error log file moved or deleted")
 )
 
 // Manager used to trigger rolling event.
 type Manager interface {
 	// Fire will return a string channel
 	// while the rolling event occoured, new file name will generate
+	// an empty name asks the writer to reopen the log file without rolling
 	Fire() chan string
 	// Close the Manager
 	Close()
 }
 
+// RotateEvent describe a finished rotation
+type RotateEvent struct {
+	// OldPath is the log file path rolled
+	OldPath string
+	// BackupPath is the path the log file renamed to
+	BackupPath string
+	// FinalPath is the rolling file path after compression, same as BackupPath if not
+	// compressed or the compression failed
+	FinalPath string
+	// Size is the bytes written into the log file rolled
+	Size int64
+	// Start is the time the log file started, End is the time it rolled
+	Start time.Time
+	End   time.Time
+	// Reason is the trigger of the rotation, e.g. TriggerTime
+	Reason string
+}
+
+// ManagerBuilder build the Manager with the given config
+type ManagerBuilder func(*Config) (Manager, error)
+
 // RollingWriter implement the io writer
 type RollingWriter interface {
 	io.Writer
+	// Rotate flush the buffered data and roll the file synchronously,
+	// it returns after the new file opened
+	Rotate() error
+	// Flush write the buffered data into file synchronously
+	Flush() error
+	// Close close the file and wait for the background compression and
+	// retention within CloseTimeout
 	Close() error
+	// CloseContext close the file and wait for the background compression
+	// and retention until ctx done
+	CloseContext(ctx context.Context) error
+	// Errors return the channel receiving the errors occurred in background
+	Errors() <-chan error
 }
 
 // LogFileFormatter log file format function
@@ -71,6 +154,9 @@ type Config struct {
 	//	[LogPath]/[FileName].[FileExtension].[TimeTag]
 	//  if compressed true
 	//	[LogPath]/[FileName].[FileExtension].gz.[TimeTag]
+	//  the '.gz' follows the extension of the Compressor, e.g. '.zst' for zstd
+	//  if rolling with TimeAndVolumeRolling, the trigger fired is tagged
+	//	[LogPath]/[FileName].[FileExtension].[Trigger].[TimeTag]
 	//
 	// NOTICE: blank field will be ignored
 	// By default we using '-' as separator, you can set it yourself
@@ -80,20 +166,49 @@ type Config struct {
 	// FileExtension defines the log file extension. By default, it's 'log'
 	FileExtension string `json:"file_extension"`
 	// FileFormatter log file path formatter for the file start write
-	// By default, append '.gz' suffix when Compress is true
+	// By default, append the compressor extension like '.gz' suffix when compress enabled
 	FileFormatter LogFileFormatter `json:"-"`
 	// MaxRemain will auto clear the roling file list, set 0 will disable auto clean
 	MaxRemain int `json:"max_remain"`
+	// MaxAge will auto clear the rolling files older than it, works with MaxRemain
+	// set 0 will disable auto clean. It can be set like "720h" in json
+	MaxAge time.Duration `json:"max_age"`
+	// MaxTotalSize will auto clear the oldest rolling files until the total size of them
+	// fit it, like 500mb or 10G, the size without unit is in bytes. Empty will disable
+	// auto clean
+	MaxTotalSize string `json:"max_total_size"`
 
 	// RollingPolicy give out the rolling policy
-	// We got 3 policies(actually, 2):
+	// We got 4 policies:
 	//
 	//	1. WithoutRolling: no rolling will happen
 	//	2. TimeRolling: rolling by time
 	//	3. VolumeRolling: rolling by file size
+	//	4. TimeAndVolumeRolling: rolling by time or file size, whichever comes first
 	RollingPolicy      int    `json:"rolling_ploicy"`
 	RollingTimePattern string `json:"rolling_time_pattern"`
 	RollingVolumeSize  string `json:"rolling_volume_size"`
+	// RollingVolumeStrict roll the file before the write which would exceed the
+	// RollingVolumeSize, instead of after the threshold reached
+	RollingVolumeStrict bool `json:"rolling_volume_strict"`
+	// RollingVolumeSplit split the write larger than RollingVolumeSize on the newline
+	// boundaries, so no truncated file exceed the size. Only works in strict mode
+	RollingVolumeSplit bool `json:"rolling_volume_split"`
+	// RollingManager is the name of a manager registered by RegisterManager,
+	// it takes place of the RollingPolicy when set
+	RollingManager string `json:"rolling_manager"`
+	// ManagerBuilder build the manager yourself, it takes place of both
+	// RollingManager and RollingPolicy when set
+	ManagerBuilder ManagerBuilder `json:"-"`
+	// ReopenSignals reopen the log file without rolling when receive the signals,
+	// cooperate with the external rotation tools like logrotate
+	ReopenSignals []os.Signal `json:"-"`
+	// Watchdog check the log file every Precision seconds, reopen the log file
+	// if it was moved or deleted by others and report ErrFileMoved
+	Watchdog bool `json:"watchdog"`
+	// ErrorHandler receive the errors and events can not be returned by Write,
+	// e.g. *RotateError, *CompressError and *RetentionError. They are also sent to Errors
+	ErrorHandler func(error) `json:"-"`
 
 	// WriterMode in 4 modes below
 	// 1. none 2. lock
@@ -101,24 +216,94 @@ type Config struct {
 	WriterMode string `json:"writer_mode"`
 	// BufferWriterThershould in Byte
 	BufferWriterThershould int `json:"buffer_thershould"`
+	// LineRecord take the newline-terminated records as the unit of write, the partial
+	// record is held until its newline written or the writer closed, so every
+	// rolling file contains whole lines only. Flush will not write the partial record.
+	// It is not supported with the WriterMode none
+	LineRecord bool `json:"line_record"`
+	// SyncPolicy is the fsync policy of the log file, one of never, write, bytes, interval
+	// and rotate. Empty is never
+	SyncPolicy string `json:"sync_policy"`
+	// SyncBytes is the bytes written between the fsync for SyncPolicyBytes,
+	// like 4096, 64k or 1mb. The size without unit is in bytes
+	SyncBytes string `json:"sync_bytes"`
+	// SyncInterval is the interval of the fsync for SyncPolicyInterval.
+	// It can be set like "1s" in json
+	SyncInterval time.Duration `json:"sync_interval"`
+	// FlushInterval flush the buffer writer every interval, so the data buffered will not
+	// stay in memory too long. 0 disable the periodic flush. It can be set like "1s" in json
+	FlushInterval time.Duration `json:"flush_interval"`
+	// BufferSize is the initial size of the buffers allocated for the asynchronous write,
+	// 0 use the package BufferSize
+	BufferSize int `json:"buffer_size"`
+	// QueueSize is the queue size for the asynchronous write and the error channel,
+	// 0 use the package QueueSize
+	QueueSize int `json:"queue_size"`
+	// Precision is the interval of the watchdog and the combined rolling in second,
+	// 0 use the package Precision
+	Precision int `json:"precision"`
+	// QueueOverflow is the policy of the asynchronous writer when the queue is full,
+	// one of drop_newest, block, drop_oldest and sample. Empty is drop_newest
+	QueueOverflow string `json:"queue_overflow"`
+	// QueueBlockTimeout limit the time write blocking on the full queue with the block
+	// and sample policies, ErrQueueFull returned after it. 0 blocks until closed
+	QueueBlockTimeout time.Duration `json:"queue_block_timeout"`
+	// QueueSampleRate keep one in every QueueSampleRate writes with the sample policy,
+	// 10 by default
+	QueueSampleRate int `json:"queue_sample_rate"`
+	// AsyncBatchSize coalesce the writes queued in the asynchronous writer into one
+	// write up to the size like 4096, 64k or 1mb, the size without unit is in bytes.
+	// Empty disable it
+	AsyncBatchSize string `json:"async_batch_size"`
+	// AsyncBatchLatency is the max time waiting for more writes to fill the batch,
+	// the Rotate and Flush wait for the batch as well. 0 coalesce the writes already
+	// queued only. It can be set like "1ms" in json
+	AsyncBatchLatency time.Duration `json:"async_batch_latency"`
 	// Compress will compress log file with gzip
 	Compress bool `json:"compress"`
+	// Compression compress log file with the compressor registered by the name,
+	// build-in gzip, zstd and lz4. It takes place of Compress when set
+	Compression string `json:"compression"`
+	// Compressor compress log file with the given compressor, it takes place of
+	// both Compression and Compress when set
+	Compressor Compressor `json:"-"`
+	// CompressLevel set the compression level for the LevelCompressor,
+	// 0 use the default level of the codec. The level out of the codec range is
+	// rejected with ErrInvalidArgument
+	CompressLevel int `json:"compress_level"`
 
 	// FilterEmptyBackup will not backup empty file if you set it true
 	FilterEmptyBackup bool `json:"filter_empty_backup"`
+
+	// OnRotate will be called after every rotation and compression finished,
+	// before the retention clean up
+	OnRotate func(RotateEvent) `json:"-"`
+
+	// Metrics receive the measurements of the writer, e.g. NewExpvarMetrics
+	Metrics Metrics `json:"-"`
+
+	// CloseTimeout limit the time Close waiting for the background compression
+	// and retention, 0 will wait until they finished. It can be set like "10s" in json
+	CloseTimeout time.Duration `json:"close_timeout"`
 }
 
-func (c *Config) fileFormat(start time.Time) (filename string) {
+func (c *Config) fileFormat(start time.Time, trigger string) (filename string) {
 	if c.FileFormatter != nil {
 		filename = c.FileFormatter(start)
-		if c.Compress && filepath.Ext(filename) != ".gz" {
-			filename += ".gz"
+		if trigger != "" {
+			filename += "." + trigger
+		}
+		if cmp, _ := c.compressor(); cmp != nil && filepath.Ext(filename) != cmp.Extension() {
+			filename += cmp.Extension()
 		}
 	} else {
 		// [path-to-log]/filename.[FileExtension].2007010215041517
 		timeTag := start.Format(c.TimeTagFormat)
-		if c.Compress {
-			filename = path.Join(c.LogPath, c.FileName+"."+c.FileExtension+".gz."+timeTag)
+		if trigger != "" {
+			timeTag = trigger + "." + timeTag
+		}
+		if cmp, _ := c.compressor(); cmp != nil {
+			filename = path.Join(c.LogPath, c.FileName+"."+c.FileExtension+cmp.Extension()+"."+timeTag)
 		} else {
 			filename = path.Join(c.LogPath, c.FileName+"."+c.FileExtension+"."+timeTag)
 		}
@@ -126,6 +311,30 @@ func (c *Config) fileFormat(start time.Time) (filename string) {
 	return
 }
 
+// bufferSize return the BufferSize, fall back to the package BufferSize
+func (c *Config) bufferSize() int {
+	if c.BufferSize > 0 {
+		return c.BufferSize
+	}
+	return BufferSize
+}
+
+// queueSize return the QueueSize, fall back to the package QueueSize
+func (c *Config) queueSize() int {
+	if c.QueueSize > 0 {
+		return c.QueueSize
+	}
+	return QueueSize
+}
+
+// precision return the Precision as duration, fall back to the package Precision
+func (c *Config) precision() time.Duration {
+	if c.Precision > 0 {
+		return time.Duration(c.Precision) * time.Second
+	}
+	return time.Duration(Precision) * time.Second
+}
+
 // NewDefaultConfig return the default config
 func NewDefaultConfig() Config {
 	return Config{
@@ -143,12 +352,65 @@ func NewDefaultConfig() Config {
 	}
 }
 
+// UnmarshalJSON unmarshal the config, durations can be given as string like "1h30m"
+// or number in nanoseconds
+func (c *Config) UnmarshalJSON(b []byte) error {
+	type config Config
+	aux := struct {
+		*config
+		MaxAge            *jsonDuration `json:"max_age"`
+		CloseTimeout      *jsonDuration `json:"close_timeout"`
+		QueueBlockTimeout *jsonDuration `json:"queue_block_timeout"`
+		FlushInterval     *jsonDuration `json:"flush_interval"`
+		SyncInterval      *jsonDuration `json:"sync_interval"`
+		AsyncBatchLatency *jsonDuration `json:"async_batch_latency"`
+	}{
+		config:            (*config)(c),
+		MaxAge:            (*jsonDuration)(&c.MaxAge),
+		CloseTimeout:      (*jsonDuration)(&c.CloseTimeout),
+		QueueBlockTimeout: (*jsonDuration)(&c.QueueBlockTimeout),
+		FlushInterval:     (*jsonDuration)(&c.FlushInterval),
+		SyncInterval:      (*jsonDuration)(&c.SyncInterval),
+		AsyncBatchLatency: (*jsonDuration)(&c.AsyncBatchLatency),
+	}
+	return json.Unmarshal(b, &aux)
+}
+
+// jsonDuration unmarshal the time.Duration from json string or number
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = jsonDuration(value)
+	case string:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = jsonDuration(duration)
+	default:
+		return ErrInvalidArgument
+	}
+	return nil
+}
+
 // LogFilePath return the absolute path on log file
 func LogFilePath(c *Config) (filepath string) {
 	filepath = path.Join(c.LogPath, c.FileName) + "." + c.FileExtension
 	return
 }
 
+// BackupFilePath return the truncated file path for the log started at start,
+// custom managers should fire the rolling event with it
+func BackupFilePath(c *Config, start time.Time) string {
+	return c.fileFormat(start, "")
+}
+
 // Option defined config option
 type Option func(*Config)
 
@@ -195,6 +457,94 @@ func WithAsynchronous() Option {
 	}
 }
 
+// WithLineRecord write the whole lines only, so no line split by rotation or flush
+func WithLineRecord() Option {
+	return func(p *Config) {
+		p.LineRecord = true
+	}
+}
+
+// WithSyncPolicy set the fsync policy of the log file
+func WithSyncPolicy(policy string) Option {
+	return func(p *Config) {
+		p.SyncPolicy = policy
+	}
+}
+
+// WithSyncBytes fsync the log file after every size written, like "1mb"
+func WithSyncBytes(size string) Option {
+	return func(p *Config) {
+		p.SyncPolicy = SyncPolicyBytes
+		p.SyncBytes = size
+	}
+}
+
+// WithSyncInterval fsync the log file every interval
+func WithSyncInterval(interval time.Duration) Option {
+	return func(p *Config) {
+		p.SyncPolicy = SyncPolicyInterval
+		p.SyncInterval = interval
+	}
+}
+
+// WithFlushInterval flush the buffer writer every interval
+func WithFlushInterval(interval time.Duration) Option {
+	return func(p *Config) {
+		p.FlushInterval = interval
+	}
+}
+
+// WithBufferSize set the initial size of the buffers for the asynchronous write
+func WithBufferSize(n int) Option {
+	return func(p *Config) {
+		p.BufferSize = n
+	}
+}
+
+// WithQueueSize set the queue size for the asynchronous write
+func WithQueueSize(n int) Option {
+	return func(p *Config) {
+		p.QueueSize = n
+	}
+}
+
+// WithPrecision set the interval of the watchdog and the combined rolling in second
+func WithPrecision(n int) Option {
+	return func(p *Config) {
+		p.Precision = n
+	}
+}
+
+// WithAsyncBatch coalesce the writes queued in the asynchronous writer into one write
+// up to size like "64k", waiting for latency at most to fill the batch
+func WithAsyncBatch(size string, latency time.Duration) Option {
+	return func(p *Config) {
+		p.AsyncBatchSize = size
+		p.AsyncBatchLatency = latency
+	}
+}
+
+// WithQueueOverflow set the policy of the asynchronous writer when the queue is full
+func WithQueueOverflow(policy string) Option {
+	return func(p *Config) {
+		p.QueueOverflow = policy
+	}
+}
+
+// WithQueueBlockTimeout set the max time write blocking on the full queue
+func WithQueueBlockTimeout(timeout time.Duration) Option {
+	return func(p *Config) {
+		p.QueueBlockTimeout = timeout
+	}
+}
+
+// WithQueueSampleRate keep one in every n writes when the queue is full with the sample policy
+func WithQueueSampleRate(n int) Option {
+	return func(p *Config) {
+		p.QueueSampleRate = n
+	}
+}
+
 // WithLock will enable the lock in writer
 // Writer will call write with the Lock to guarantee the parallel safe
 func WithLock() Option {
@@ -224,6 +574,28 @@ func WithCompress() Option {
 	}
 }
 
+// WithCompression will auto compress the tuncated log file with the compressor
+// registered by the name, e.g. zstd
+func WithCompression(name string) Option {
+	return func(p *Config) {
+		p.Compression = name
+	}
+}
+
+// WithCompressor will auto compress the tuncated log file with the given compressor
+func WithCompressor(compressor Compressor) Option {
+	return func(p *Config) {
+		p.Compressor = compressor
+	}
+}
+
+// WithCompressLevel set the compression level, e.g. 1 to 9 for gzip
+func WithCompressLevel(level int) Option {
+	return func(p *Config) {
+		p.CompressLevel = level
+	}
+}
+
 // WithMaxRemain enable the auto deletion for old file when exceed the given max value
 // Bydefault -1 will disable the auto deletion
 func WithMaxRemain(max int) Option {
@@ -232,6 +604,21 @@ func WithMaxRemain(max int) Option {
 	}
 }
 
+// WithMaxAge enable the auto deletion for the file older than the given age
+func WithMaxAge(age time.Duration) Option {
+	return func(p *Config) {
+		p.MaxAge = age
+	}
+}
+
+// WithMaxTotalSize enable the auto deletion for old files when the total size
+// exceed the given size, e.g. 10G
+func WithMaxTotalSize(size string) Option {
+	return func(p *Config) {
+		p.MaxTotalSize = size
+	}
+}
+
 // WithoutRolling set no rolling policy
 func WithoutRollingPolicy() Option {
 	return func(p *Config) {
@@ -255,3 +642,86 @@ func WithRollingVolumeSize(size string) Option {
 		p.RollingVolumeSize = size
 	}
 }
+
+// WithRollingVolumeStrict roll the file before the write which would exceed the
+// rolling volume size
+func WithRollingVolumeStrict() Option {
+	return func(p *Config) {
+		p.RollingVolumeStrict = true
+	}
+}
+
+// WithRollingVolumeSplit enable the strict volume rolling and split the write
+// larger than rolling volume size on newline boundaries
+func WithRollingVolumeSplit() Option {
+	return func(p *Config) {
+		p.RollingVolumeStrict = true
+		p.RollingVolumeSplit = true
+	}
+}
+
+// WithRollingTimeAndVolume rolling the file by time pattern or by volume size,
+// whichever comes first
+func WithRollingTimeAndVolume(pattern, size string) Option {
+	return func(p *Config) {
+		p.RollingPolicy = TimeAndVolumeRolling
+		p.RollingTimePattern = pattern
+		p.RollingVolumeSize = size
+	}
+}
+
+// WithRollingManager rolling the file with the manager registered by the name
+func WithRollingManager(name string) Option {
+	return func(p *Config) {
+		p.RollingManager = name
+	}
+}
+
+// WithManager rolling the file with the manager built by the given builder
+func WithManager(builder ManagerBuilder) Option {
+	return func(p *Config) {
+		p.ManagerBuilder = builder
+	}
+}
+
+// WithReopenOnSignal reopen the log file when receive the signals, e.g. syscall.SIGHUP
+func WithReopenOnSignal(sigs ...os.Signal) Option {
+	return func(p *Config) {
+		p.ReopenSignals = sigs
+	}
+}
+
+// WithWatchdog reopen the log file once it was moved or deleted by others
+func WithWatchdog() Option {
+	return func(p *Config) {
+		p.Watchdog = true
+	}
+}
+
+// WithErrorHandler set the handler receive the errors can not be returned by Write
+func WithErrorHandler(handler func(error)) Option {
+	return func(p *Config) {
+		p.ErrorHandler = handler
+	}
+}
+
+// WithCloseTimeout limit the time Close waiting for the background jobs
+func WithCloseTimeout(timeout time.Duration) Option {
+	return func(p *Config) {
+		p.CloseTimeout = timeout
+	}
+}
+
+// WithOnRotate set the callback called after every rotation finished
+func WithOnRotate(callback func(RotateEvent)) Option {
+	return func(p *Config) {
+		p.OnRotate = callback
+	}
+}
+
+// WithMetrics set the metrics receive the measurements of the writer
+func WithMetrics(metrics Metrics) Option {
+	return func(p *Config) {
+		p.Metrics = metrics
+	}
+}
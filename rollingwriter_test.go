@@ -1,7 +1,9 @@
 package rollingwriter
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -32,3 +34,25 @@ func TestOptions(t *testing.T) {
 	}
 	assert.Equal(t, cfg, destcfg)
 }
+
+func TestUnmarshalConfig(t *testing.T) {
+	cfg := NewDefaultConfig()
+	assert.Nil(t, json.Unmarshal([]byte(`{"file_name":"foo","max_age":"720h"}`), &cfg))
+	assert.Equal(t, "foo", cfg.FileName)
+	assert.Equal(t, "./log", cfg.LogPath)
+	assert.Equal(t, 720*time.Hour, cfg.MaxAge)
+
+	assert.Nil(t, json.Unmarshal([]byte(`{"max_age":1000}`), &cfg))
+	assert.Equal(t, time.Duration(1000), cfg.MaxAge)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`{"max_age":"30 days"}`), &cfg))
+
+	assert.Nil(t, json.Unmarshal([]byte(`{"queue_overflow":"block","queue_block_timeout":"100ms"}`), &cfg))
+	assert.Equal(t, OverflowBlock, cfg.QueueOverflow)
+	assert.Equal(t, 100*time.Millisecond, cfg.QueueBlockTimeout)
+
+	assert.Nil(t, json.Unmarshal([]byte(`{"buffer_size":4096,"queue_size":64,"precision":5}`), &cfg))
+	assert.Equal(t, 4096, cfg.BufferSize)
+	assert.Equal(t, 64, cfg.QueueSize)
+	assert.Equal(t, 5, cfg.Precision)
+}
@@ -1,29 +1,55 @@
 package rollingwriter
 
 import (
-	"compress/gzip"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
 	"os"
-	"path"
-	"sort"
-	"strings"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
-	"unsafe"
 )
 
 // Writer provide a synchronous file writer
-// if Lock is set true, write will be guaranteed by lock
+// if Lock is set true, write will be guaranteed by lock.
+// Without lock the writes are safe for concurrent use and rotation, they go to the
+// file directly and the file is swapped on rotation without blocking them.
+// The LineRecord is not supported without lock, NewWriter return ErrInvalidArgument
 type Writer struct {
-	m             Manager
-	file          *os.File
-	absPath       string
-	fire          chan string
-	cf            *Config
-	rollingfilech chan string
+	m         Manager
+	ref       *fileRef
+	absPath   string
+	fire      chan string
+	cf        *Config
+	retention *retention
+	metrics   Metrics
+
+	// errChan receive the errors can not be returned by Write
+	errChan chan error
+	// jobs track the background compression and retention
+	jobs *sync.WaitGroup
+	// openAt is the time current file start to write
+	openAt time.Time
+	// rotating serialize the rotations and reopens, the writes go on meanwhile
+	rotating *sync.Mutex
+
+	// the file will be rolled once the size reach the threshold.
+	// threshold 0 disable the volume rolling
+	threshold int64
+	// partial is the record without newline held by LineRecord
+	partial []byte
+
+	// unsynced is the bytes written since the last fsync, synced once it reach
+	// syncBytes with SyncPolicyBytes
+	unsynced  int64
+	syncBytes int64
+	// syncStop stop the fsync ticker of SyncPolicyInterval
+	syncStop chan int
+	syncOnce *sync.Once
 }
 
 // LockedWriter provide a synchronous writer with lock
@@ -36,27 +62,47 @@ type LockedWriter struct {
 // AsynchronousWriter provide a asynchronous writer with the writer to confirm the write
 type AsynchronousWriter struct {
 	Writer
-	ctx     chan int
-	queue   chan []byte
-	errChan chan error
-	closed  int32
-	wg      sync.WaitGroup
+	ctx    chan int
+	queue  chan []byte
+	rotate chan chan error
+	flush  chan chan error
+	closed int32
+	wg     sync.WaitGroup
+	// writeErrs receive the errors of the queued writes, returned by the next Write,
+	// the background errors like compression failures are kept in errChan only
+	writeErrs chan error
+	// pool is the buffer pool for the queued writes
+	pool sync.Pool
+	// batch coalesce the queued writes up to batchSize into one write
+	batch     []byte
+	batchSize int
+
+	// overflowed count the writes meet the full queue for sampling
+	overflowed   int64
+	droppedBytes int64
+	droppedLines int64
 }
 
 // BufferWriter merge some write operations into one.
+// The writes are appended into the buffer, and the flusher goroutine swaps it
+// with the spare buffer then writes it into file, once the buffer exceeds the
+// BufferWriterThershould, every FlushInterval, or on Flush, Rotate and Close.
+// All the file operations are done by the flusher, so the data are written in the
+// order of the Write calls, and the data of the Write calls returned are written
+// (into the old file for Rotate) when Flush, Rotate or Close returns
 type BufferWriter struct {
 	Writer
-	buf     *[]byte // store the pointer for atomic opertaion
-	swaping int32
+	buf     []byte // the buffer appended by Write
+	closed  bool
+	lockBuf Locker // protect the buf and closed by spinlock
 
-	lockBuf Locker // protect the buffer by spinlock
-}
-
-// buffer pool for asynchronous writer
-var _asyncBufferPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, BufferSize)
-	},
+	// spare is the buffer swapped out, owned by the flusher
+	spare  []byte
+	kick   chan struct{}
+	flush  chan chan error
+	rotate chan chan error
+	ctx    chan int
+	wg     sync.WaitGroup
 }
 
 // NewWriterFromConfig generate the rollingWriter with given config
@@ -70,6 +116,52 @@ func NewWriterFromConfig(c *Config) (RollingWriter, error) {
 		c.FileExtension = "log"
 	}
 
+	compressor, err := c.compressor()
+	if err != nil {
+		return nil, err
+	}
+	// the level is checked with a throwaway writer, not at the first compression
+	if lc, ok := compressor.(LevelCompressor); ok && c.CompressLevel != 0 {
+		cw, err := lc.NewLevelWriter(io.Discard, c.CompressLevel)
+		if err != nil {
+			return nil, ErrInvalidArgument
+		}
+		cw.Close()
+	}
+
+	switch c.SyncPolicy {
+	case "", SyncPolicyNever, SyncPolicyWrite, SyncPolicyRotate:
+	case SyncPolicyBytes:
+		if _, err := parseSize(c.SyncBytes); err != nil {
+			return nil, err
+		}
+	case SyncPolicyInterval:
+		if c.SyncInterval <= 0 {
+			return nil, ErrInvalidArgument
+		}
+	default:
+		return nil, ErrInvalidArgument
+	}
+
+	switch c.QueueOverflow {
+	case "", OverflowDropNewest, OverflowBlock, OverflowDropOldest, OverflowSample:
+	default:
+		return nil, ErrInvalidArgument
+	}
+	// the partial record can not be shared by the concurrent writes without lock
+	if c.WriterMode == "none" && c.LineRecord {
+		return nil, ErrInvalidArgument
+	}
+
+	for _, size := range []string{c.AsyncBatchSize, c.MaxTotalSize} {
+		if size == "" {
+			continue
+		}
+		if _, err := parseSize(size); err != nil {
+			return nil, err
+		}
+	}
+
 	// make dir for path if not exist
 	if err := os.MkdirAll(c.LogPath, 0700); err != nil {
 		return nil, err
@@ -77,8 +169,13 @@ func NewWriterFromConfig(c *Config) (RollingWriter, error) {
 
 	filepath := LogFilePath(c)
 	// open the file and get the FD
-	file, err := os.OpenFile(filepath, DefaultFileFlag, DefaultFileMode)
+	file, err := openFile(filepath, DefaultFileFlag, DefaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
@@ -91,54 +188,39 @@ func NewWriterFromConfig(c *Config) (RollingWriter, error) {
 	var rollingWriter RollingWriter
 	writer := Writer{
 		m:       mng,
-		file:    file,
+		ref:     &fileRef{file: file, size: info.Size()},
 		absPath: filepath,
 		fire:    mng.Fire(),
 		cf:      c,
+		metrics: c.metrics(),
+		errChan: make(chan error, c.queueSize()),
+		jobs:    &sync.WaitGroup{},
+		openAt:  time.Now(),
+
+		rotating: &sync.Mutex{},
+		syncOnce: &sync.Once{},
+	}
+	if c.SyncPolicy == SyncPolicyBytes {
+		writer.syncBytes, _ = parseSize(c.SyncBytes)
+	}
+	if c.SyncPolicy == SyncPolicyInterval {
+		writer.syncStop = make(chan int)
+	}
+	if m, ok := mng.(*manager); ok {
+		writer.threshold = m.thresholdSize
 	}
 
-	if c.MaxRemain > 0 {
-		writer.rollingfilech = make(chan string, c.MaxRemain)
-		dir, err := os.ReadDir(c.LogPath)
-		if err != nil {
+	// finish the compressions interrupted last time before tracking the files
+	if err := recoverCompress(c); err != nil {
+		mng.Close()
+		return nil, err
+	}
+
+	if c.MaxRemain > 0 || c.MaxAge > 0 || c.MaxTotalSize != "" {
+		if writer.retention, err = newRetention(c, writer.report); err != nil {
 			mng.Close()
 			return nil, err
 		}
-
-		files := make([]string, 0, 10)
-		for _, fi := range dir {
-			if fi.IsDir() {
-				continue
-			}
-
-			fileName := c.FileName + "." + c.FileExtension + "."
-			if strings.Contains(fi.Name(), fileName) {
-				fileSuffix := path.Ext(fi.Name())
-				if len(fileSuffix) > 1 {
-					_, err := time.Parse(c.TimeTagFormat, fileSuffix[1:])
-					if err == nil {
-						files = append(files, fi.Name())
-					}
-				}
-			}
-		}
-		sort.Slice(files, func(i, j int) bool {
-			fileSuffix1 := path.Ext(files[i])
-			fileSuffix2 := path.Ext(files[j])
-			t1, _ := time.Parse(c.TimeTagFormat, fileSuffix1[1:])
-			t2, _ := time.Parse(c.TimeTagFormat, fileSuffix2[1:])
-			return t1.Before(t2)
-		})
-
-		for _, file := range files {
-		retry:
-			select {
-			case writer.rollingfilech <- path.Join(c.LogPath, file):
-			default:
-				writer.DoRemove()
-				goto retry // remove the file and retry
-			}
-		}
 	}
 
 	switch c.WriterMode {
@@ -150,30 +232,59 @@ func NewWriterFromConfig(c *Config) (RollingWriter, error) {
 		}
 	case "async":
 		wr := &AsynchronousWriter{
-			ctx:     make(chan int),
-			queue:   make(chan []byte, QueueSize),
-			errChan: make(chan error, QueueSize),
-			wg:      sync.WaitGroup{},
-			closed:  0,
-			Writer:  writer,
+			ctx:    make(chan int),
+			queue:  make(chan []byte, c.queueSize()),
+			rotate: make(chan chan error),
+			flush:  make(chan chan error),
+			wg:     sync.WaitGroup{},
+			closed: 0,
+			Writer: writer,
+
+			writeErrs: make(chan error, c.queueSize()),
+		}
+		bufferSize := c.bufferSize()
+		wr.pool.New = func() interface{} {
+			return make([]byte, bufferSize)
+		}
+		if c.AsyncBatchSize != "" {
+			size, _ := parseSize(c.AsyncBatchSize)
+			wr.batchSize = int(size)
+			wr.batch = make([]byte, 0, wr.batchSize)
 		}
 		// start the asynchronous writer
 		wr.wg.Add(1)
 		go wr.writer()
-		wr.wg.Wait()
 		rollingWriter = wr
 	case "buffer":
 		// bufferWriterThershould unit is Byte
-		bf := make([]byte, 0, c.BufferWriterThershould*2)
-		rollingWriter = &BufferWriter{
-			Writer:  writer,
-			buf:     &bf,
-			swaping: 0,
+		wr := &BufferWriter{
+			Writer: writer,
+			buf:    make([]byte, 0, c.BufferWriterThershould*2),
+			spare:  make([]byte, 0, c.BufferWriterThershould*2),
+			kick:   make(chan struct{}, 1),
+			flush:  make(chan chan error),
+			rotate: make(chan chan error),
+			ctx:    make(chan int),
 		}
+		// start the flusher
+		wr.wg.Add(1)
+		go wr.flusher(c.FlushInterval)
+		rollingWriter = wr
 	default:
 		mng.Close()
 		return nil, ErrInvalidArgument
 	}
+
+	if c.SyncPolicy == SyncPolicyInterval {
+		go rollingWriter.(interface{ syncer(time.Duration) }).syncer(c.SyncInterval)
+	}
+	if m, ok := mng.(*manager); ok && c.Watchdog {
+		w := rollingWriter.(interface {
+			moved() bool
+			report(error)
+		})
+		m.startWatchdog(c, w.moved, w.report)
+	}
 	return rollingWriter, nil
 }
 
@@ -208,39 +319,36 @@ func NewWriterFromConfigFile(path string) (RollingWriter, error) {
 
 // DoRemove will delete the oldest file
 func (w *Writer) DoRemove() {
-	select {
-	case file := <-w.rollingfilech:
-		// remove the oldest file
-		if err := os.Remove(file); err != nil {
-			log.Println("error in remove log file", file, err)
-		}
+	if w.retention != nil {
+		w.retention.removeOldest()
 	}
 }
 
-// CompressFile compress log file write into .gz
+// CompressFile compress log file with the compressor configured, gzip by default
 func (w *Writer) CompressFile(oldfile *os.File, cmpname string) error {
-	cmpfile, err := os.OpenFile(cmpname, DefaultFileFlag, DefaultFileMode)
-	if err != nil {
-		return err
-	}
-	defer cmpfile.Close()
-	gw := gzip.NewWriter(cmpfile)
-	defer gw.Close()
+	return compressFile(w.cf, oldfile, cmpname)
+}
 
-	if _, err = oldfile.Seek(0, 0); err != nil {
-		return err
-	}
+// Errors return the channel receiving the errors can not be returned by Write,
+// like the failures of background compression and retention. The errors will be
+// dropped when the channel is full
+func (w *Writer) Errors() <-chan error {
+	return w.errChan
+}
 
-	if _, err = io.Copy(gw, oldfile); err != nil {
-		if errR := os.Remove(cmpname); errR != nil {
-			return errR
-		}
-		return err
+// report send the error to ErrorHandler and the error channel
+func (w *Writer) report(err error) {
+	if w.cf.ErrorHandler != nil {
+		w.cf.ErrorHandler(err)
+	}
+	select {
+	case w.errChan <- err:
+	default:
 	}
-	return nil
 }
 
 // AsynchronousWriterErrorChan return the error channel for asyn writer
+// Deprecated: use Errors instead, which works for all writer modes
 func AsynchronousWriterErrorChan(wr RollingWriter) (chan error, error) {
 	if w, ok := wr.(*AsynchronousWriter); ok {
 		return w.errChan, nil
@@ -249,11 +357,59 @@ func AsynchronousWriterErrorChan(wr RollingWriter) (chan error, error) {
 }
 
 // Reopen do the rotate, open new file and swap FD then trate the old FD
+// Empty file will reopen the log file in place without rolling
 func (w *Writer) Reopen(file string) error {
+	if file == "" {
+		return w.reopen()
+	}
+
+	// the file fired by build-in manager is rolled by time
+	if m, ok := w.m.(*manager); ok {
+		if m.combined {
+			return w.rollTime(file, m.precision)
+		}
+		return w.rotate(file, TriggerTime)
+	}
+	return w.rotate(file, TriggerManager)
+}
+
+// rotate roll the log file to file for the trigger
+func (w *Writer) rotate(file, trigger string) error {
+	w.rotating.Lock()
+	defer w.rotating.Unlock()
+	return w.rotateLocked(file, trigger)
+}
+
+// rollTime roll the file by time unless the file rolled within the precision, so
+// the time event fired before a volume rolling does not roll the file again
+func (w *Writer) rollTime(file string, precision time.Duration) error {
+	w.rotating.Lock()
+	defer w.rotating.Unlock()
+	if time.Since(w.openAt) < precision {
+		return nil
+	}
+	return w.rotateLocked(file, TriggerTime)
+}
+
+// rollVolume roll the file by volume if it is still the current one,
+// so the writes reaching the threshold at the same time roll the file once
+func (w *Writer) rollVolume(ref *fileRef) error {
+	w.rotating.Lock()
+	defer w.rotating.Unlock()
+	if w.current() != ref {
+		return nil
+	}
+	return w.rotateLocked(w.rollingFileName(TriggerVolume), TriggerVolume)
+}
+
+// rotateLocked rename the log file while the writes going on, then swap in the new
+// file and close the old one after the writes on it finished. The rotating must be held
+func (w *Writer) rotateLocked(file, trigger string) error {
+	current := w.current()
 	if w.cf.FilterEmptyBackup {
-		fileInfo, err := w.file.Stat()
+		fileInfo, err := current.file.Stat()
 		if err != nil {
-			return err
+			return &RotateError{Op: "stat", Path: w.absPath, Backup: file, Err: err}
 		}
 
 		if fileInfo.Size() == 0 {
@@ -261,55 +417,290 @@ func (w *Writer) Reopen(file string) error {
 		}
 	}
 
-	w.file.Close()
-	if err := os.Rename(w.absPath, file); err != nil {
-		return err
+	// the file will be compressed from the tempfile
+	compressor, _ := w.cf.compressor()
+	file = freeBackupName(file)
+	backup := file
+	if compressor != nil {
+		backup = file + ".tmp"
+	}
+
+	if err := os.Rename(w.absPath, backup); err != nil {
+		return &RotateError{Op: "rename", Path: w.absPath, Backup: backup, Err: err}
 	}
-	newfile, err := os.OpenFile(w.absPath, DefaultFileFlag, DefaultFileMode)
+	newfile, err := openFile(w.absPath, DefaultFileFlag, DefaultFileMode)
 	if err != nil {
-		return err
+		// move the file back, it is still in use
+		os.Rename(backup, w.absPath)
+		return &RotateError{Op: "open", Path: w.absPath, Backup: backup, Err: err}
 	}
 
-	w.file = newfile
-
-	go func() {
-		if w.cf.Compress {
-			if err := os.Rename(file, file+".tmp"); err != nil {
-				log.Println("error in compress rename tempfile", err)
-				return
-			}
-			oldfile, err := os.OpenFile(file+".tmp", DefaultFileFlag, DefaultFileMode)
-			if err != nil {
-				log.Println("error in open tempfile", err)
-				return
+	old := w.replace(newfile, 0)
+	atomic.StoreInt64(&w.unsynced, 0)
+	if w.durable() {
+		if err := old.file.Sync(); err != nil {
+			w.report(&RotateError{Op: "sync", Path: w.absPath, Backup: backup, Err: err})
+		}
+	}
+	old.file.Close()
+	if w.durable() {
+		// persist the rename and the new file
+		for _, dir := range []string{filepath.Dir(w.absPath), filepath.Dir(backup)} {
+			if err := syncDir(dir); err != nil {
+				w.report(&RotateError{Op: "syncdir", Path: w.absPath, Backup: backup, Err: err})
 			}
-			var closeOnce sync.Once
-			defer closeOnce.Do(func() { oldfile.Close() })
-			if err := w.CompressFile(oldfile, file); err != nil {
-				log.Println("error in compress log file", err)
-				return
+			if filepath.Dir(w.absPath) == filepath.Dir(backup) {
+				break
 			}
-			closeOnce.Do(func() { oldfile.Close() })
-			err = os.Remove(file + ".tmp")
+		}
+	}
+	event := RotateEvent{
+		OldPath:    w.absPath,
+		BackupPath: backup,
+		FinalPath:  file,
+		Size:       atomic.LoadInt64(&old.size),
+		Start:      w.openAt,
+		End:        time.Now(),
+		Reason:     trigger,
+	}
+	w.openAt = event.End
+	w.metrics.Rotated(trigger)
+	if w.retention != nil {
+		// track the file in rolling order, before compress
+		w.retention.add(file, compressor != nil)
+	}
+
+	w.jobs.Add(1)
+	go func() {
+		defer w.jobs.Done()
+		if compressor != nil {
+			err := doCompress(func() error {
+				start := time.Now()
+				err := compressRolling(w.cf, file)
+				w.metrics.Compressed(time.Since(start), err)
+				return err
+			})
 			if err != nil {
-				log.Println("error in remove tempfile", err)
-				return
+				w.report(&CompressError{Source: backup, Target: file, Err: err})
+				// keep the uncompressed backup in place of the compressed one never made
+				if _, serr := os.Stat(file); os.IsNotExist(serr) {
+					event.FinalPath = backup
+				}
 			}
 		}
 
-		if w.cf.MaxRemain > 0 {
-		retry:
-			select {
-			case w.rollingfilech <- file:
-			default:
-				w.DoRemove()
-				goto retry // remove the file and retry
-			}
+		if w.cf.OnRotate != nil {
+			w.cf.OnRotate(event)
+		}
+		if w.retention != nil {
+			w.retention.done(file, event.FinalPath)
 		}
 	}()
 	return nil
 }
 
+// freeBackupName return file if no backup takes it, otherwise append the sequence
+// like file.1, so the rollings within the precision of the time tag never
+// overwrite each other
+func freeBackupName(file string) string {
+	name := file
+	for i := 1; ; i++ {
+		_, err := os.Stat(name)
+		_, errTmp := os.Stat(name + ".tmp")
+		if os.IsNotExist(err) && os.IsNotExist(errTmp) {
+			return name
+		}
+		name = file + "." + strconv.Itoa(i)
+	}
+}
+
+// rollingFileName generate the truncated file name for the trigger, fall back to
+// the config format with the time current file opened for the custom managers.
+// It should be called with rotating locked
+func (w *Writer) rollingFileName(trigger string) string {
+	if m, ok := w.m.(*manager); ok {
+		return m.rollingFileName(w.cf, trigger)
+	}
+	return w.cf.fileFormat(w.openAt, "")
+}
+
+// Rotate roll the file right now
+func (w *Writer) Rotate() error {
+	w.rotating.Lock()
+	defer w.rotating.Unlock()
+	return w.rotateLocked(w.rollingFileName(TriggerManual), TriggerManual)
+}
+
+// Rotate lock and roll the file
+func (w *LockedWriter) Rotate() error {
+	w.Lock()
+	defer w.Unlock()
+	return w.Writer.Rotate()
+}
+
+// Rotate hand over the rotation to the writer goroutine, the data queued
+// before will be written into the old file
+func (w *AsynchronousWriter) Rotate() error {
+	if atomic.LoadInt32(&w.closed) != 0 {
+		return ErrClosed
+	}
+	ch := make(chan error, 1)
+	select {
+	case w.rotate <- ch:
+		return <-ch
+	case <-w.ctx:
+		return ErrClosed
+	}
+}
+
+// Rotate hand over the rotation to the flusher, the data buffered
+// before will be written into the old file
+func (w *BufferWriter) Rotate() error {
+	return w.request(w.rotate)
+}
+
+// Flush do nothing as the writer is not buffered
+func (w *Writer) Flush() error {
+	return nil
+}
+
+// Flush hand over the flush to the writer goroutine, it returns after
+// the data queued before written into file, with the first error of the writes
+func (w *AsynchronousWriter) Flush() error {
+	if atomic.LoadInt32(&w.closed) != 0 {
+		return ErrClosed
+	}
+	ch := make(chan error, 1)
+	select {
+	case w.flush <- ch:
+		return <-ch
+	case <-w.ctx:
+		return ErrClosed
+	}
+}
+
+// Flush hand over the flush to the flusher, it returns after
+// the data buffered before written into file
+func (w *BufferWriter) Flush() error {
+	return w.request(w.flush)
+}
+
+// request send the request to the flusher and wait for the result
+func (w *BufferWriter) request(req chan chan error) error {
+	ch := make(chan error, 1)
+	select {
+	case req <- ch:
+		return <-ch
+	case <-w.ctx:
+		return ErrClosed
+	}
+}
+
+// flusher do all the file operations of the buffer writer in order until closed,
+// the buffer is flushed before the rotation and reopen
+func (w *BufferWriter) flusher(interval time.Duration) {
+	defer w.wg.Done()
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	report := func(err error) {
+		if err != nil {
+			w.report(err)
+		}
+	}
+	for {
+		select {
+		case filename := <-w.fire:
+			report(w.swap())
+			report(w.Reopen(filename))
+		case <-w.kick:
+			report(w.swap())
+		case <-tick:
+			report(w.swap())
+		case ch := <-w.flush:
+			ch <- w.swap()
+		case ch := <-w.rotate:
+			if err := w.swap(); err != nil {
+				ch <- err
+				continue
+			}
+			ch <- w.Writer.Rotate()
+		case <-w.ctx:
+			// write the remaining data
+			report(w.swap())
+			return
+		}
+	}
+}
+
+// swap take the buffer out and write it into file,
+// Write goes on with the spare buffer meanwhile
+func (w *BufferWriter) swap() error {
+	w.lockBuf.Lock()
+	b := w.buf
+	w.buf = w.spare[:0]
+	w.lockBuf.Unlock()
+
+	w.spare = b
+	if len(b) == 0 {
+		return nil
+	}
+	w.metrics.Flushed(len(b))
+	_, err := w.write(b)
+	return err
+}
+
+// reopen close and open the log file again without rename, so that the writer
+// follows the file moved or truncated by the external rotation tools
+func (w *Writer) reopen() error {
+	w.rotating.Lock()
+	defer w.rotating.Unlock()
+
+	newfile, err := openFile(w.absPath, DefaultFileFlag, DefaultFileMode)
+	if err != nil {
+		return err
+	}
+	info, err := newfile.Stat()
+	if err != nil {
+		newfile.Close()
+		return err
+	}
+
+	w.replace(newfile, info.Size()).file.Close()
+	return nil
+}
+
+// syncDir fsync the directory to persist the renames in it
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// moved check whether the opened file is not the one on the path any more. It holds
+// the rotating, so the file renamed by the rotation in progress is not taken as moved
+func (w *Writer) moved() bool {
+	w.rotating.Lock()
+	defer w.rotating.Unlock()
+
+	opened, err := w.current().file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(w.absPath)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	return !os.SameFile(opened, current)
+}
+
 func (w *Writer) Write(b []byte) (int, error) {
 	var ok = false
 	for !ok {
@@ -323,9 +714,182 @@ func (w *Writer) Write(b []byte) (int, error) {
 		}
 	}
 
-	fp := atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&w.file)))
-	file := (*os.File)(fp)
-	return file.Write(b)
+	return w.write(b)
+}
+
+// write do the file write and account the file size,
+// rolling the file once the size reach the volume threshold
+func (w *Writer) write(b []byte) (int, error) {
+	if w.cf.LineRecord {
+		return w.writeRecords(b)
+	}
+	return w.writeBytes(b)
+}
+
+// writeRecords write the newline-terminated records only, the partial record at
+// the tail is held until its newline comes, so that the rotations and flushes
+// never split a record
+func (w *Writer) writeRecords(b []byte) (int, error) {
+	i := bytes.LastIndexByte(b, '\n')
+	if i < 0 {
+		w.partial = append(w.partial, b...)
+		return len(b), nil
+	}
+
+	records := b[:i+1]
+	held := len(w.partial)
+	if held > 0 {
+		records = append(w.partial, records...)
+	}
+	n, err := w.writeBytes(records)
+	w.partial = append(w.partial[:0], b[i+1:]...)
+	if err != nil {
+		if n -= held; n < 0 {
+			n = 0
+		}
+		return n, err
+	}
+	return len(b), nil
+}
+
+// writeBytes write b and roll the file by volume
+func (w *Writer) writeBytes(b []byte) (int, error) {
+	if w.threshold > 0 && w.cf.RollingVolumeStrict {
+		return w.writeStrict(b)
+	}
+
+	ref, n, err := w.writeFile(b)
+	if w.threshold > 0 && atomic.LoadInt64(&ref.size) >= w.threshold {
+		if err := w.rollVolume(ref); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// writeStrict roll the file before the write which would exceed the volume threshold.
+// The write larger than threshold will be split if RollingVolumeSplit is set
+func (w *Writer) writeStrict(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		chunk := b
+		ref := w.current()
+		if size := atomic.LoadInt64(&ref.size); size+int64(len(b)) > w.threshold {
+			if size > 0 {
+				if err = w.rollVolume(ref); err != nil {
+					return
+				}
+				continue
+			}
+			if w.cf.RollingVolumeSplit {
+				chunk = splitChunk(b, w.threshold, w.cf.LineRecord)
+			}
+		}
+
+		var nn int
+		_, nn, err = w.writeFile(chunk)
+		n += nn
+		if err != nil {
+			return
+		}
+		b = b[len(chunk):]
+	}
+	return
+}
+
+// splitChunk return the head of b within limit, cut on the last newline if there is one.
+// The record larger than limit is kept whole if record set, otherwise it is cut on limit
+func splitChunk(b []byte, limit int64, record bool) []byte {
+	if int64(len(b)) <= limit {
+		return b
+	}
+	if i := bytes.LastIndexByte(b[:limit], '\n'); i >= 0 {
+		return b[:i+1]
+	}
+	if record {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			return b[:i+1]
+		}
+		return b
+	}
+	return b[:limit]
+}
+
+// writeFile write into the current file and account the file size,
+// return the file written
+func (w *Writer) writeFile(b []byte) (*fileRef, int, error) {
+	ref := w.acquire()
+	defer ref.release()
+	n, err := ref.file.Write(b)
+	atomic.AddInt64(&ref.size, int64(n))
+	w.metrics.BytesWritten(n)
+	if err == nil {
+		err = w.syncWrite(ref.file, n)
+	}
+	return ref, n, err
+}
+
+// durable return true if the file should be synced on rotation and close
+func (w *Writer) durable() bool {
+	return w.cf.SyncPolicy != "" && w.cf.SyncPolicy != SyncPolicyNever
+}
+
+// syncWrite sync the file after the write of n bytes with SyncPolicyWrite and SyncPolicyBytes
+func (w *Writer) syncWrite(file *os.File, n int) error {
+	switch w.cf.SyncPolicy {
+	case SyncPolicyWrite:
+		return file.Sync()
+	case SyncPolicyBytes:
+		if atomic.AddInt64(&w.unsynced, int64(n)) >= w.syncBytes {
+			atomic.StoreInt64(&w.unsynced, 0)
+			return file.Sync()
+		}
+	}
+	return nil
+}
+
+// syncer sync the file every interval until closed
+func (w *Writer) syncer(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ref := w.acquire()
+			// the file closed on close will be synced there
+			if err := ref.file.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				w.report(err)
+			}
+			ref.release()
+		case <-w.syncStop:
+			return
+		}
+	}
+}
+
+// closeFile stop the fsync ticker, write the partial record held,
+// sync the file if required by SyncPolicy then close it
+func (w *Writer) closeFile() error {
+	w.syncOnce.Do(func() {
+		if w.syncStop != nil {
+			close(w.syncStop)
+		}
+	})
+
+	// the partial record is the last one
+	if len(w.partial) > 0 {
+		w.writeFile(w.partial)
+		w.partial = nil
+	}
+
+	ref := w.current()
+	ref.drain()
+	if w.durable() {
+		if err := ref.file.Sync(); err != nil {
+			ref.file.Close()
+			return err
+		}
+	}
+	return ref.file.Close()
 }
 
 func (w *LockedWriter) Write(b []byte) (n int, err error) {
@@ -344,19 +908,19 @@ func (w *LockedWriter) Write(b []byte) (n int, err error) {
 		}
 	}
 
-	n, err = w.file.Write(b)
+	n, err = w.write(b)
 	w.Unlock()
 	return
 }
 
-// Only when the error channel is empty, otherwise nothing will write and the last error will be
-// returned the error channel
+// Only when no queued write failed, otherwise nothing will write and the error of the
+// queued write will be returned
 func (w *AsynchronousWriter) Write(b []byte) (int, error) {
 	if atomic.LoadInt32(&w.closed) == 0 {
 		var ok = false
 		for !ok {
 			select {
-			case err := <-w.errChan:
+			case err := <-w.writeErrs:
 				// NOTE this error caused by last write maybe ignored
 				return 0, err
 			default:
@@ -364,133 +928,353 @@ func (w *AsynchronousWriter) Write(b []byte) (int, error) {
 			}
 		}
 
-		select {
-		case w.queue <- append(_asyncBufferPool.Get().([]byte)[0:0], b...)[:len(b)]:
-			return len(b), nil
-		default:
-			return 0, ErrQueueFull
+		buf := append(w.pool.Get().([]byte)[0:0], b...)[:len(b)]
+		if err := w.enqueue(buf); err != nil {
+			w.drop(buf)
+			return 0, err
 		}
+		return len(b), nil
 	}
 
 	return 0, ErrClosed
 }
 
+// enqueue put the write into queue, handle the full queue with the QueueOverflow policy
+func (w *AsynchronousWriter) enqueue(b []byte) error {
+	select {
+	case w.queue <- b:
+		w.metrics.QueueDepth(len(w.queue))
+		return nil
+	default:
+	}
+
+	switch w.cf.QueueOverflow {
+	case OverflowBlock:
+		return w.enqueueBlocking(b)
+	case OverflowDropOldest:
+		for {
+			// drop one oldest write, then try to put again
+			select {
+			case old := <-w.queue:
+				w.drop(old)
+			default:
+			}
+			select {
+			case w.queue <- b:
+				w.metrics.QueueDepth(len(w.queue))
+				return nil
+			default:
+			}
+		}
+	case OverflowSample:
+		rate := int64(w.cf.QueueSampleRate)
+		if rate <= 0 {
+			rate = 10
+		}
+		if atomic.AddInt64(&w.overflowed, 1)%rate == 0 {
+			return w.enqueueBlocking(b)
+		}
+		return ErrQueueFull
+	default:
+		return ErrQueueFull
+	}
+}
+
+// enqueueBlocking wait for the room in queue until QueueBlockTimeout or closed
+func (w *AsynchronousWriter) enqueueBlocking(b []byte) error {
+	var timeout <-chan time.Time
+	if w.cf.QueueBlockTimeout > 0 {
+		timer := time.NewTimer(w.cf.QueueBlockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case w.queue <- b:
+		w.metrics.QueueDepth(len(w.queue))
+		return nil
+	case <-w.ctx:
+		return ErrClosed
+	case <-timeout:
+		return ErrQueueFull
+	}
+}
+
+// drop account the write dropped and recycle the buffer
+func (w *AsynchronousWriter) drop(b []byte) {
+	atomic.AddInt64(&w.droppedBytes, int64(len(b)))
+	atomic.AddInt64(&w.droppedLines, 1)
+	w.metrics.Dropped()
+	w.pool.Put(b)
+}
+
+// Dropped return the bytes and lines dropped by the overflow policy, every write
+// is taken as a line as the loggers do
+func (w *AsynchronousWriter) Dropped() (bytes, lines int64) {
+	return atomic.LoadInt64(&w.droppedBytes), atomic.LoadInt64(&w.droppedLines)
+}
+
 // writer do the asynchronous write independently
 // Take care of reopen, I am not sure if there need no lock
 func (w *AsynchronousWriter) writer() {
 	var err error
-	w.wg.Done()
+	defer w.wg.Done()
 	for {
 		select {
 		case filename := <-w.fire:
-			if err = w.Reopen(filename); err != nil && len(w.errChan) < cap(w.errChan) {
-				w.errChan <- err
+			if err = w.Reopen(filename); err != nil {
+				w.report(err)
 			}
 		case b := <-w.queue:
-			if _, err = w.file.Write(b); err != nil && len(w.errChan) < cap(w.errChan) {
-				w.errChan <- err
+			if err = w.writeQueued(b, w.cf.AsyncBatchLatency); err != nil {
+				// returned by the next Write
+				select {
+				case w.writeErrs <- err:
+				default:
+				}
+			}
+		case ch := <-w.rotate:
+			if err = w.drain(); err != nil {
+				ch <- err
+				continue
+			}
+			ch <- w.Writer.Rotate()
+		case ch := <-w.flush:
+			// return the error of the writes queued before, written by drain or not
+			if err = w.drain(); err == nil {
+				select {
+				case err = <-w.writeErrs:
+				default:
+				}
 			}
-			_asyncBufferPool.Put(b)
+			ch <- err
 		case <-w.ctx:
 			return
 		}
 	}
 }
 
+// Write append the data into buffer, and kick the flusher once the buffer
+// exceeds the BufferWriterThershould
 func (w *BufferWriter) Write(b []byte) (int, error) {
-	var ok = false
-	for !ok {
+	w.lockBuf.Lock()
+	if w.closed {
+		w.lockBuf.Unlock()
+		return 0, ErrClosed
+	}
+	w.buf = append(w.buf, b...)
+	full := len(w.buf) > w.cf.BufferWriterThershould
+	w.lockBuf.Unlock()
+
+	if full {
 		select {
-		case filename := <-w.fire:
-			if err := w.Reopen(filename); err != nil {
-				return 0, err
-			}
+		case w.kick <- struct{}{}:
 		default:
-			ok = true
 		}
 	}
+	return len(b), nil
+}
 
-	w.lockBuf.Lock()
-	*(w.buf) = append(*w.buf, b...)
-	w.lockBuf.Unlock()
+// closeContext return the context for Close, with CloseTimeout if set
+func (w *Writer) closeContext() (context.Context, context.CancelFunc) {
+	if w.cf.CloseTimeout > 0 {
+		return context.WithTimeout(context.Background(), w.cf.CloseTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// wait for the background compression and retention jobs until ctx done
+func (w *Writer) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.jobs.Wait()
+		close(done)
+	}()
 
-	if len(*w.buf) > w.cf.BufferWriterThershould && atomic.CompareAndSwapInt32(&w.swaping, 0, 1) {
-		nb := make([]byte, 0, w.cf.BufferWriterThershould*2)
-		ob := atomic.SwapPointer((*unsafe.Pointer)(unsafe.Pointer(&w.buf)), (unsafe.Pointer(&nb)))
-		w.file.Write(*(*[]byte)(ob))
-		atomic.StoreInt32(&w.swaping, 0)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ErrCloseTimeout
 	}
-	return len(b), nil
 }
 
-// Close the file and return
+// Close the file and wait for the background jobs within CloseTimeout
 func (w *Writer) Close() error {
-	defer recover()
+	ctx, cancel := w.closeContext()
+	defer cancel()
+	return w.CloseContext(ctx)
+}
+
+// CloseContext close the file and wait for the background jobs until ctx done
+func (w *Writer) CloseContext(ctx context.Context) error {
+	err := func() error {
+		defer recover()
 
-	w.m.Close()
+		w.m.Close()
+
+		return w.closeFile()
+	}()
 
-	return (*os.File)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&w.file)))).Close()
+	if errW := w.wait(ctx); err == nil {
+		err = errW
+	}
+	return err
 }
 
-// Close lock and close the file
+// Close lock and close the file, see CloseContext
 func (w *LockedWriter) Close() error {
-	w.Lock()
-	defer w.Unlock()
+	ctx, cancel := w.closeContext()
+	defer cancel()
+	return w.CloseContext(ctx)
+}
 
-	func() {
-		defer recover()
-		w.m.Close()
+// CloseContext lock and close the file, then wait for the background jobs
+func (w *LockedWriter) CloseContext(ctx context.Context) error {
+	err := func() error {
+		w.Lock()
+		defer w.Unlock()
+
+		func() {
+			defer recover()
+			w.m.Close()
+		}()
+
+		return w.closeFile()
 	}()
 
-	return w.file.Close()
+	if errW := w.wait(ctx); err == nil {
+		err = errW
+	}
+	return err
 }
 
-// Close set closed and close the file once
+// Close set closed and close the file once, see CloseContext
 func (w *AsynchronousWriter) Close() error {
+	ctx, cancel := w.closeContext()
+	defer cancel()
+	return w.CloseContext(ctx)
+}
+
+// CloseContext set closed and close the file once, then wait for the background jobs
+func (w *AsynchronousWriter) CloseContext(ctx context.Context) error {
 	if atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
 		close(w.ctx)
-		w.onClose()
+		// wait for the writer exit then write the remaining data
+		w.wg.Wait()
+		w.drain()
 
 		func() {
 			defer recover()
 			w.m.Close()
 		}()
-		return w.file.Close()
+		err := w.closeFile()
+		if errW := w.wait(ctx); err == nil {
+			err = errW
+		}
+		return err
 	}
 	return ErrClosed
 }
 
-// onClose process remaining bufferd data for asynchronous writer
-func (w *AsynchronousWriter) onClose() {
+// drain process remaining bufferd data for asynchronous writer, return the first error
+func (w *AsynchronousWriter) drain() error {
 	var err error
 	for {
 		select {
 		case b := <-w.queue:
 			// flush all remaining field
-			if _, err = w.file.Write(b); err != nil {
-				select {
-				case w.errChan <- err:
-				default:
-					_asyncBufferPool.Put(b)
-					return
-				}
+			if errW := w.writeQueued(b, 0); err == nil {
+				err = errW
 			}
-			_asyncBufferPool.Put(b)
 		default: // after the queue was empty, return
-			return
+			return err
 		}
 	}
 }
 
-// Close bufferWriter flush all buffered write then close file
+// writeQueued write the queued b into file, coalesced with the writes queued
+// after it if AsyncBatchSize set. The error is reported and returned
+func (w *AsynchronousWriter) writeQueued(b []byte, latency time.Duration) error {
+	if w.batchSize > 0 {
+		w.batch = w.coalesce(append(w.batch[:0], b...), latency)
+		w.pool.Put(b)
+		b = w.batch
+	} else {
+		defer w.pool.Put(b)
+	}
+
+	w.metrics.QueueDepth(len(w.queue))
+	_, err := w.write(b)
+	if err != nil {
+		w.report(err)
+	}
+	return err
+}
+
+// coalesce append the queued writes into batch in order until it reach the batchSize,
+// wait for the writes coming within latency if the queue is empty
+func (w *AsynchronousWriter) coalesce(batch []byte, latency time.Duration) []byte {
+	var timeout <-chan time.Time
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for len(batch) < w.batchSize {
+		select {
+		case b := <-w.queue:
+			batch = append(batch, b...)
+			w.pool.Put(b)
+			continue
+		default:
+		}
+		if timeout == nil {
+			return batch
+		}
+
+		select {
+		case b := <-w.queue:
+			batch = append(batch, b...)
+			w.pool.Put(b)
+		case <-timeout:
+			return batch
+		case <-w.ctx:
+			return batch
+		}
+	}
+	return batch
+}
+
+// Close bufferWriter flush all buffered write then close file, see CloseContext
 func (w *BufferWriter) Close() error {
+	ctx, cancel := w.closeContext()
+	defer cancel()
+	return w.CloseContext(ctx)
+}
+
+// CloseContext reject the writes, wait for the flusher writing the remaining data
+// then close file once, then wait for the background jobs
+func (w *BufferWriter) CloseContext(ctx context.Context) error {
 	w.lockBuf.Lock()
-	defer w.lockBuf.Unlock()
+	closed := w.closed
+	w.closed = true
+	w.lockBuf.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	close(w.ctx)
+	w.wg.Wait()
+
 	func() {
 		defer recover()
 		w.m.Close()
 	}()
-
-	w.file.Write(*w.buf)
-	return w.file.Close()
+	err := w.closeFile()
+	if errW := w.wait(ctx); err == nil {
+		err = errW
+	}
+	return err
 }
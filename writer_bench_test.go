@@ -133,3 +133,56 @@ func BenchmarkParallelBufferWrite(b *testing.B) {
 	w.Close()
 	clean()
 }
+
+func benchmarkParallelRollingWrite(b *testing.B, mode string) {
+	var l int = 1024
+	bf := make([]byte, l)
+	rand.Read(bf)
+
+	cfg := NewDefaultConfig()
+	cfg.LogPath = b.TempDir()
+	cfg.FileName = "rolling"
+	cfg.TimeTagFormat = "20060102150405,000000000"
+	cfg.RollingPolicy = VolumeRolling
+	cfg.RollingVolumeSize = "4mb"
+	cfg.MaxRemain = 2
+	cfg.WriterMode = mode
+	w, err := NewWriterFromConfig(&cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w.Write(bf)
+		}
+	})
+	w.Close()
+}
+
+func BenchmarkParallelRollingWrite(b *testing.B) {
+	benchmarkParallelRollingWrite(b, "none")
+}
+
+func BenchmarkParallelLockedRollingWrite(b *testing.B) {
+	benchmarkParallelRollingWrite(b, "lock")
+}
+
+func BenchmarkParallelAsynBatchWrite(b *testing.B) {
+	var l int = 1024
+	bf := make([]byte, l)
+	rand.Read(bf)
+
+	w, err := NewWriter(WithLogPath(b.TempDir()), WithFileName("batch"), WithoutRollingPolicy(),
+		WithAsynchronous(), WithAsyncBatch("64k", 0))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w.Write(bf)
+		}
+	})
+	w.Close()
+}
@@ -1,10 +1,23 @@
 package rollingwriter
 
 import (
+	"compress/gzip"
+	"context"
+	"errors"
 	"io"
 	"math/rand"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func clean() {
@@ -62,14 +75,15 @@ func newBufferWriter() *BufferWriter {
 }
 
 func TestNewWriter(t *testing.T) {
-	if _, err := NewWriter(
-		WithTimeTagFormat("200601021504"), WithLogPath("./"), WithFileName("foo"),
+	w, err := NewWriter(
+		WithTimeTagFormat("200601021504"), WithLogPath(t.TempDir()), WithFileName("foo"),
 		WithAsynchronous(), WithBuffer(), WithBufferThershould(8), WithCompress(), WithLock(),
 		WithMaxRemain(3), WithRollingVolumeSize("100mb"), WithRollingTimePattern("0 0 0 * * *"),
-	); err != nil {
+	)
+	if err != nil {
 		t.Fatal("error in test new writer", err)
 	}
-	os.Remove("./foo.log")
+	w.Close()
 }
 
 func TestWrite(t *testing.T) {
@@ -262,7 +276,876 @@ func TestCompress(t *testing.T) {
 		rand.Read(bf)
 		writer.Write(bf)
 	}
-	writer.CompressFile(writer.file, "./test/unittest.gz")
+	writer.CompressFile(writer.current().file, "./test/unittest.gz")
 	writer.Close()
 	clean()
 }
+
+func TestVolumeRolling(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(WithLogPath(dir), WithFileName("volume"), WithLock(),
+		WithTimeTagFormat("20060102150405,000000000"), WithRollingVolumeSize("1k"))
+	assert.Nil(t, err)
+
+	for i := 0; i < 50; i++ {
+		w.Write(make([]byte, 100))
+	}
+	w.Close()
+
+	files, _ := filepath.Glob(path.Join(dir, "volume.log.*"))
+	assert.Equal(t, 4, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1100), info.Size())
+	}
+	info, err := os.Stat(path.Join(dir, "volume.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(600), info.Size())
+}
+
+func TestVolumeRollingDefaultTimeTag(t *testing.T) {
+	dir := t.TempDir()
+	// the rollings within a minute get the same time tag
+	w, err := NewWriter(WithLogPath(dir), WithFileName("volume"), WithLock(), WithRollingVolumeSize("1k"))
+	assert.Nil(t, err)
+
+	for i := 0; i < 50; i++ {
+		w.Write(make([]byte, 100))
+	}
+	w.Close()
+
+	files, _ := filepath.Glob(path.Join(dir, "volume.log*"))
+	assert.Equal(t, 5, len(files))
+	var total int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		assert.Nil(t, err)
+		total += info.Size()
+	}
+	assert.Equal(t, int64(5000), total)
+}
+
+func TestStrictVolumeRolling(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(WithLogPath(dir), WithFileName("strict"), WithLock(),
+		WithTimeTagFormat("20060102150405,000000000"), WithRollingVolumeSize("1k"), WithRollingVolumeSplit())
+	assert.Nil(t, err)
+
+	line := []byte("0123456789abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklmnopqrs\n")
+	for i := 0; i < 50; i++ {
+		w.Write(line)
+	}
+	// an oversized write will be split on the newlines
+	big := make([]byte, 0, 40*len(line))
+	for i := 0; i < 40; i++ {
+		big = append(big, line...)
+	}
+	n, err := w.Write(big)
+	assert.Nil(t, err)
+	assert.Equal(t, len(big), n)
+	// an oversized write without newline will be cut at the threshold
+	n, err = w.Write(make([]byte, 3000))
+	assert.Nil(t, err)
+	assert.Equal(t, 3000, n)
+	w.Close()
+
+	files, _ := filepath.Glob(path.Join(dir, "strict.log*"))
+	var total int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		assert.Nil(t, err)
+		assert.True(t, info.Size() <= 1024, file)
+		total += info.Size()
+	}
+	assert.Equal(t, int64(90*len(line)+3000), total)
+}
+
+func TestRotate(t *testing.T) {
+	for _, mode := range []string{"none", "lock", "async", "buffer"} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := NewDefaultConfig()
+			cfg.LogPath = dir
+			cfg.FileName = "rotate"
+			cfg.WriterMode = mode
+			cfg.RollingPolicy = WithoutRolling
+			w, err := NewWriterFromConfig(&cfg)
+			assert.Nil(t, err)
+
+			w.Write([]byte("before rotate\n"))
+			assert.Nil(t, w.Rotate())
+			w.Write([]byte("after rotate\n"))
+			w.Close()
+
+			files, _ := filepath.Glob(path.Join(dir, "rotate.log.*"))
+			assert.Equal(t, 1, len(files))
+			b, _ := os.ReadFile(files[0])
+			assert.Equal(t, "before rotate\n", string(b))
+			b, _ = os.ReadFile(path.Join(dir, "rotate.log"))
+			assert.Equal(t, "after rotate\n", string(b))
+		})
+	}
+}
+
+func TestReopenOnSignal(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(WithLogPath(dir), WithFileName("signal"), WithLock(),
+		WithoutRollingPolicy(), WithReopenOnSignal(syscall.SIGHUP))
+	assert.Nil(t, err)
+	defer w.Close()
+
+	w.Write([]byte("before logrotate\n"))
+	// move the log file away like logrotate create mode
+	logfile := path.Join(dir, "signal.log")
+	assert.Nil(t, os.Rename(logfile, logfile+".1"))
+	p, _ := os.FindProcess(os.Getpid())
+	assert.Nil(t, p.Signal(syscall.SIGHUP))
+
+	reopened := false
+	for i := 0; i < 100 && !reopened; i++ {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("after logrotate\n"))
+		_, err = os.Stat(logfile)
+		reopened = err == nil
+	}
+	assert.True(t, reopened)
+
+	b, _ := os.ReadFile(logfile + ".1")
+	assert.Equal(t, "before logrotate\n", string(b))
+}
+
+func TestWatchdog(t *testing.T) {
+	dir := t.TempDir()
+	moved := make(chan error, 1)
+	w, err := NewWriter(WithLogPath(dir), WithFileName("watchdog"), WithAsynchronous(),
+		WithoutRollingPolicy(), WithWatchdog(), WithErrorHandler(func(err error) {
+			select {
+			case moved <- err:
+			default:
+			}
+		}))
+	assert.Nil(t, err)
+
+	logfile := path.Join(dir, "watchdog.log")
+	assert.Nil(t, os.Remove(logfile))
+
+	select {
+	case err := <-moved:
+		assert.True(t, errors.Is(err, ErrFileMoved))
+	case <-time.After(3 * time.Second):
+		t.Fatal("watchdog not fired")
+	}
+
+	recreated := false
+	for i := 0; i < 100 && !recreated; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, err = os.Stat(logfile)
+		recreated = err == nil
+	}
+	assert.True(t, recreated)
+
+	// the background error is not returned by the next write
+	n, err := w.Write([]byte("hello\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+	assert.Nil(t, w.Close())
+	assert.True(t, errors.Is(<-w.(*AsynchronousWriter).Errors(), ErrFileMoved))
+	content, err := os.ReadFile(logfile)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", string(content))
+}
+
+func TestWatchdogDuringRotation(t *testing.T) {
+	dir := t.TempDir()
+	wr, err := NewWriter(WithLogPath(dir), WithFileName("rotating"), WithLock(), WithoutRollingPolicy())
+	assert.Nil(t, err)
+	defer wr.Close()
+	w := wr.(*LockedWriter)
+
+	// the rotation renamed the file but not replaced it yet
+	logfile := path.Join(dir, "rotating.log")
+	w.rotating.Lock()
+	assert.Nil(t, os.Rename(logfile, logfile+".1"))
+	moved := make(chan bool, 1)
+	go func() { moved <- w.moved() }()
+	select {
+	case <-moved:
+		t.Fatal("checked during the rotation")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	newfile, err := openFile(logfile, DefaultFileFlag, DefaultFileMode)
+	assert.Nil(t, err)
+	w.replace(newfile, 0).file.Close()
+	w.rotating.Unlock()
+	assert.False(t, <-moved)
+
+	assert.Nil(t, os.Remove(logfile))
+	assert.True(t, w.moved())
+}
+
+func TestMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{LogPath: dir, FileName: "age", FileExtension: "log", TimeTagFormat: "200601021504"}
+	expired := BackupFilePath(&cfg, time.Now().Add(-48*time.Hour))
+	fresh := BackupFilePath(&cfg, time.Now().Add(-time.Hour))
+	for _, file := range []string{expired, fresh} {
+		assert.Nil(t, os.WriteFile(file, []byte("backup\n"), 0644))
+	}
+	assert.Nil(t, os.Chtimes(expired, time.Now().Add(-47*time.Hour), time.Now().Add(-47*time.Hour)))
+
+	w, err := NewWriter(WithLogPath(dir), WithFileName("age"), WithMaxAge(24*time.Hour), WithMaxRemain(5))
+	assert.Nil(t, err)
+	defer w.Close()
+
+	_, err = os.Stat(expired)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(fresh)
+	assert.Nil(t, err)
+
+	// the fresh file expired after the rotation
+	assert.Nil(t, os.Chtimes(fresh, time.Now().Add(-25*time.Hour), time.Now().Add(-25*time.Hour)))
+	w.Write([]byte("rotate\n"))
+	assert.Nil(t, w.Rotate())
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(fresh); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMaxTotalSize(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{LogPath: dir, FileName: "total", FileExtension: "log", TimeTagFormat: "200601021504"}
+	files := make([]string, 0, 3)
+	for i := 3; i > 0; i-- {
+		file := BackupFilePath(&cfg, time.Now().Add(-time.Duration(i)*time.Hour))
+		assert.Nil(t, os.WriteFile(file, make([]byte, 600), 0644))
+		files = append(files, file)
+	}
+
+	w, err := NewWriter(WithLogPath(dir), WithFileName("total"), WithMaxTotalSize("1k"))
+	assert.Nil(t, err)
+	defer w.Close()
+
+	for i, file := range files {
+		_, err = os.Stat(file)
+		assert.Equal(t, i < 2, os.IsNotExist(err), file)
+	}
+
+	w.Write(make([]byte, 600))
+	assert.Nil(t, w.Rotate())
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(files[2]); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, os.IsNotExist(err))
+
+	for _, size := range []string{"10X", "1.5G", "0"} {
+		_, err := NewWriter(WithLogPath(t.TempDir()), WithMaxTotalSize(size))
+		assert.Equal(t, ErrInvalidArgument, err, size)
+	}
+}
+
+func TestCloseWaitJobs(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(WithLogPath(dir), WithFileName("close"), WithCompress(), WithMaxRemain(1))
+	assert.Nil(t, err)
+	w.Write([]byte("compressed before close returns\n"))
+	assert.Nil(t, w.Rotate())
+	assert.Nil(t, w.Close())
+
+	files, _ := filepath.Glob(path.Join(dir, "close.log.gz.*"))
+	assert.Equal(t, 1, len(files))
+	tmps, _ := filepath.Glob(path.Join(dir, "*.tmp"))
+	assert.Equal(t, 0, len(tmps))
+
+	// occupy the compression pool so the job can not finish
+	doCompress(func() error { return nil })
+	release := make(chan struct{})
+	for i := 0; i < cap(compressPool); i++ {
+		go doCompress(func() error { <-release; return nil })
+	}
+	for running, _ := CompressStats(); running < cap(compressPool); running, _ = CompressStats() {
+		time.Sleep(time.Millisecond)
+	}
+
+	w, err = NewWriter(WithLogPath(dir), WithFileName("close"), WithCompress())
+	assert.Nil(t, err)
+	w.Write([]byte("compress blocked\n"))
+	assert.Nil(t, w.Rotate())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, ErrCloseTimeout, w.CloseContext(ctx))
+
+	close(release)
+	w.(*LockedWriter).jobs.Wait()
+}
+
+func TestOnRotate(t *testing.T) {
+	dir := t.TempDir()
+	var lock sync.Mutex
+	events := make([]RotateEvent, 0, 2)
+	w, err := NewWriter(WithLogPath(dir), WithFileName("event"), WithCompress(),
+		WithTimeTagFormat("20060102150405,000000000"), WithRollingVolumeSize("1k"),
+		WithOnRotate(func(event RotateEvent) {
+			lock.Lock()
+			events = append(events, event)
+			lock.Unlock()
+		}))
+	assert.Nil(t, err)
+
+	start := time.Now()
+	w.Write(make([]byte, 2048))
+	w.Write([]byte("rotate manually\n"))
+	assert.Nil(t, w.Rotate())
+	assert.Nil(t, w.Close())
+
+	assert.Equal(t, 2, len(events))
+	reasons := map[string]int64{TriggerVolume: 2048, TriggerManual: 16}
+	for _, event := range events {
+		assert.Equal(t, path.Join(dir, "event.log"), event.OldPath)
+		assert.Equal(t, event.FinalPath+".tmp", event.BackupPath)
+		assert.Equal(t, reasons[event.Reason], event.Size)
+		assert.False(t, event.Start.Before(start.Add(-time.Second)))
+		assert.True(t, event.End.After(event.Start))
+		_, err := os.Stat(event.FinalPath)
+		assert.Nil(t, err)
+	}
+}
+
+func TestRenameOpenFile(t *testing.T) {
+	// the log file is renamed on rotation while it is still open, on Windows as well
+	dir := t.TempDir()
+	name := path.Join(dir, "open.log")
+	f, err := openFile(name, DefaultFileFlag, DefaultFileMode)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	f.Write([]byte("before rename\n"))
+	assert.Nil(t, os.Rename(name, name+".1"))
+	f.Write([]byte("after rename\n"))
+
+	b, err := os.ReadFile(name + ".1")
+	assert.Nil(t, err)
+	assert.Equal(t, "before rename\nafter rename\n", string(b))
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCombinedRollingDedup(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(WithLogPath(dir), WithFileName("combined"), WithLock(),
+		WithTimeTagFormat("20060102150405,000000000"), WithRollingTimeAndVolume("0 0 0 1 1 *", "1k"))
+	assert.Nil(t, err)
+
+	// the time event queued before the volume rolling
+	timeFile := BackupFilePath(w.(*LockedWriter).cf, time.Now())
+	w.Write(make([]byte, 2048))
+	assert.Nil(t, w.(*LockedWriter).Reopen(timeFile))
+	assert.Nil(t, w.Close())
+
+	files, _ := filepath.Glob(path.Join(dir, "combined.log.*"))
+	assert.Equal(t, 1, len(files))
+	_, err = os.Stat(timeFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+type failCompressor struct{}
+
+func (failCompressor) Extension() string { return ".fail" }
+
+func (failCompressor) NewWriter(io.Writer) (io.WriteCloser, error) {
+	return nil, errors.New("compress failed")
+}
+
+func TestErrors(t *testing.T) {
+	for _, mode := range []Option{WithLock(), WithAsynchronous(), WithBuffer()} {
+		dir := t.TempDir()
+		handled := make(chan error, 1)
+		w, err := NewWriter(WithLogPath(dir), WithFileName("errors"), WithoutRollingPolicy(), mode,
+			WithCompressor(failCompressor{}), WithErrorHandler(func(err error) { handled <- err }))
+		assert.Nil(t, err)
+
+		w.Write([]byte("report the compress error\n"))
+		assert.Nil(t, w.Rotate())
+
+		var cerr *CompressError
+		select {
+		case err := <-w.Errors():
+			assert.True(t, errors.As(err, &cerr))
+			assert.Equal(t, cerr.Source, cerr.Target+".tmp")
+		case <-time.After(3 * time.Second):
+			t.Fatal("compress error not reported")
+		}
+		assert.Equal(t, cerr, <-handled)
+
+		var rerr *RotateError
+		assert.Nil(t, os.Remove(path.Join(dir, "errors.log")))
+		assert.True(t, errors.As(w.Rotate(), &rerr))
+		assert.Equal(t, "rename", rerr.Op)
+		assert.True(t, os.IsNotExist(rerr.Err))
+		w.Close()
+	}
+}
+
+func TestCompressFailureRetention(t *testing.T) {
+	dir := t.TempDir()
+	rotated := make(chan RotateEvent, 3)
+	w, err := NewWriter(WithLogPath(dir), WithFileName("fallback"), WithLock(), WithoutRollingPolicy(),
+		WithTimeTagFormat("20060102150405,000000000"), WithCompressor(failCompressor{}), WithMaxRemain(1),
+		WithOnRotate(func(event RotateEvent) { rotated <- event }))
+	assert.Nil(t, err)
+
+	var last RotateEvent
+	for i := 0; i < 3; i++ {
+		w.Write([]byte("keep the uncompressed backup\n"))
+		assert.Nil(t, w.Rotate())
+		select {
+		case last = <-rotated:
+			assert.Equal(t, last.BackupPath, last.FinalPath)
+		case <-time.After(3 * time.Second):
+			t.Fatal("OnRotate not called")
+		}
+	}
+	assert.Nil(t, w.Close())
+
+	// the backups failed to compress are pruned by MaxRemain
+	files, _ := filepath.Glob(path.Join(dir, "fallback.log.*"))
+	assert.Equal(t, []string{last.BackupPath}, files)
+}
+
+// stallMetrics stall the asynchronous writer on the file write until released
+type stallMetrics struct {
+	nopMetrics
+	release chan struct{}
+}
+
+func (m stallMetrics) BytesWritten(int) { <-m.release }
+
+func TestQueueOverflow(t *testing.T) {
+	// fill return the writer with the queue full, the first write is stalled in writer
+	fill := func(t *testing.T, ops ...Option) (*AsynchronousWriter, string, chan struct{}) {
+		dir := t.TempDir()
+		m := stallMetrics{release: make(chan struct{})}
+		ops = append([]Option{WithLogPath(dir), WithFileName("overflow"), WithoutRollingPolicy(),
+			WithAsynchronous(), WithQueueSize(4), WithMetrics(m)}, ops...)
+		wr, err := NewWriter(ops...)
+		assert.Nil(t, err)
+		w := wr.(*AsynchronousWriter)
+
+		w.Write([]byte("0\n"))
+		for len(w.queue) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		for i := 1; i <= cap(w.queue); i++ {
+			_, err = w.Write([]byte(strconv.Itoa(i) + "\n"))
+			assert.Nil(t, err)
+		}
+		return w, path.Join(dir, "overflow.log"), m.release
+	}
+
+	t.Run(OverflowDropNewest, func(t *testing.T) {
+		w, logfile, release := fill(t)
+		_, err := w.Write([]byte("5\n"))
+		assert.Equal(t, ErrQueueFull, err)
+		close(release)
+		w.Close()
+
+		b, _ := os.ReadFile(logfile)
+		assert.Equal(t, "0\n1\n2\n3\n4\n", string(b))
+		bytes, lines := w.Dropped()
+		assert.Equal(t, int64(2), bytes)
+		assert.Equal(t, int64(1), lines)
+	})
+
+	t.Run(OverflowDropOldest, func(t *testing.T) {
+		w, logfile, release := fill(t, WithQueueOverflow(OverflowDropOldest))
+		_, err := w.Write([]byte("5\n"))
+		assert.Nil(t, err)
+		close(release)
+		w.Close()
+
+		b, _ := os.ReadFile(logfile)
+		assert.Equal(t, "0\n2\n3\n4\n5\n", string(b))
+		_, lines := w.Dropped()
+		assert.Equal(t, int64(1), lines)
+	})
+
+	t.Run(OverflowBlock, func(t *testing.T) {
+		w, logfile, release := fill(t, WithQueueOverflow(OverflowBlock), WithQueueBlockTimeout(20*time.Millisecond))
+		_, err := w.Write([]byte("5\n"))
+		assert.Equal(t, ErrQueueFull, err)
+
+		w.cf.QueueBlockTimeout = 0
+		done := make(chan error)
+		go func() {
+			_, err := w.Write([]byte("6\n"))
+			done <- err
+		}()
+		close(release)
+		assert.Nil(t, <-done)
+		w.Close()
+
+		b, _ := os.ReadFile(logfile)
+		assert.Equal(t, "0\n1\n2\n3\n4\n6\n", string(b))
+	})
+
+	t.Run(OverflowSample, func(t *testing.T) {
+		w, logfile, release := fill(t, WithQueueOverflow(OverflowSample), WithQueueSampleRate(2))
+		_, err := w.Write([]byte("5\n"))
+		assert.Equal(t, ErrQueueFull, err)
+		done := make(chan error)
+		go func() {
+			_, err := w.Write([]byte("6\n"))
+			done <- err
+		}()
+		close(release)
+		assert.Nil(t, <-done)
+		w.Close()
+
+		b, _ := os.ReadFile(logfile)
+		assert.Equal(t, "0\n1\n2\n3\n4\n6\n", string(b))
+		_, lines := w.Dropped()
+		assert.Equal(t, int64(1), lines)
+	})
+
+	_, err := NewWriter(WithLogPath(t.TempDir()), WithQueueOverflow("unknown"))
+	assert.Equal(t, ErrInvalidArgument, err)
+}
+
+func TestWriterSizes(t *testing.T) {
+	dir := t.TempDir()
+	small, err := NewWriter(WithLogPath(dir), WithFileName("small"), WithAsynchronous(),
+		WithQueueSize(8), WithBufferSize(16), WithPrecision(3))
+	assert.Nil(t, err)
+	defer small.Close()
+	global, err := NewWriter(WithLogPath(dir), WithFileName("global"), WithAsynchronous())
+	assert.Nil(t, err)
+	defer global.Close()
+
+	w := small.(*AsynchronousWriter)
+	assert.Equal(t, 8, cap(w.queue))
+	assert.Equal(t, 8, cap(w.errChan))
+	assert.Equal(t, 16, len(w.pool.Get().([]byte)))
+	assert.Equal(t, 3*time.Second, w.m.(*manager).precision)
+
+	w = global.(*AsynchronousWriter)
+	assert.Equal(t, QueueSize, cap(w.queue))
+	assert.Equal(t, BufferSize, len(w.pool.Get().([]byte)))
+	assert.Equal(t, time.Duration(Precision)*time.Second, w.m.(*manager).precision)
+}
+
+func TestFlush(t *testing.T) {
+	data := []byte("flush the buffered data\n")
+	for _, mode := range []Option{WithLock(), WithAsynchronous(), WithBuffer()} {
+		dir := t.TempDir()
+		w, err := NewWriter(WithLogPath(dir), WithFileName("flush"), WithoutRollingPolicy(),
+			mode, WithBufferThershould(1024))
+		assert.Nil(t, err)
+
+		w.Write(data)
+		assert.Nil(t, w.Flush())
+		b, _ := os.ReadFile(path.Join(dir, "flush.log"))
+		assert.Equal(t, data, b)
+		w.Close()
+	}
+
+	dir := t.TempDir()
+	w, err := NewWriter(WithLogPath(dir), WithFileName("interval"), WithoutRollingPolicy(),
+		WithBuffer(), WithBufferThershould(1024), WithFlushInterval(10*time.Millisecond))
+	assert.Nil(t, err)
+	defer w.Close()
+
+	w.Write(data)
+	var b []byte
+	for i := 0; i < 100 && len(b) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		b, _ = os.ReadFile(path.Join(dir, "interval.log"))
+	}
+	assert.Equal(t, data, b)
+}
+
+func TestSyncPolicy(t *testing.T) {
+	policies := []Option{WithSyncPolicy(SyncPolicyWrite), WithSyncBytes("1k"),
+		WithSyncInterval(time.Millisecond), WithSyncPolicy(SyncPolicyRotate)}
+	for _, policy := range policies {
+		for _, mode := range []Option{WithLock(), WithAsynchronous(), WithBuffer()} {
+			dir := t.TempDir()
+			w, err := NewWriter(WithLogPath(dir), WithFileName("sync"), WithoutRollingPolicy(),
+				WithTimeTagFormat("20060102150405,000000000"), mode, policy)
+			assert.Nil(t, err)
+
+			w.Write([]byte("synced before rotation\n"))
+			assert.Nil(t, w.Rotate())
+			w.Write([]byte("synced before close\n"))
+			time.Sleep(5 * time.Millisecond)
+			assert.Nil(t, w.Close())
+
+			files, _ := filepath.Glob(path.Join(dir, "sync.log.*"))
+			assert.Equal(t, 1, len(files))
+			b, _ := os.ReadFile(files[0])
+			assert.Equal(t, "synced before rotation\n", string(b))
+			b, _ = os.ReadFile(path.Join(dir, "sync.log"))
+			assert.Equal(t, "synced before close\n", string(b))
+		}
+	}
+
+	w, err := NewWriter(WithLogPath(t.TempDir()), WithFileName("bytes"), WithSyncBytes("1k"))
+	assert.Nil(t, err)
+	lw := w.(*LockedWriter)
+	w.Write(make([]byte, 600))
+	assert.Equal(t, int64(600), atomic.LoadInt64(&lw.unsynced))
+	w.Write(make([]byte, 600))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&lw.unsynced))
+	w.Close()
+
+	for _, policy := range []Option{WithSyncPolicy("unknown"), WithSyncPolicy(SyncPolicyBytes), WithSyncInterval(0),
+		WithSyncBytes("abc"), WithSyncBytes("mb"), WithSyncBytes("-1k"), WithSyncBytes("0")} {
+		_, err := NewWriter(WithLogPath(t.TempDir()), policy)
+		assert.Equal(t, ErrInvalidArgument, err)
+	}
+}
+
+func TestLineRecord(t *testing.T) {
+	_, err := NewWriterFromConfig(&Config{LogPath: t.TempDir(), WriterMode: "none", LineRecord: true})
+	assert.Equal(t, ErrInvalidArgument, err)
+
+	line := "0123456789abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklmnopqrstuvwxyz\n"
+	for _, mode := range []Option{WithLock(), WithAsynchronous(), WithBuffer()} {
+		dir := t.TempDir()
+		w, err := NewWriter(WithLogPath(dir), WithFileName("record"), WithTimeTagFormat("20060102150405,000000000"),
+			WithRollingVolumeSize("1k"), mode, WithBufferThershould(100), WithLineRecord())
+		assert.Nil(t, err)
+
+		// every line is written in pieces
+		for i := 0; i < 100; i++ {
+			cut := rand.Intn(len(line))
+			w.Write([]byte(line[:cut]))
+			w.Write([]byte(line[cut:]))
+			if i%30 == 0 {
+				w.Write([]byte(line[:cut]))
+				assert.Nil(t, w.Rotate())
+				w.Write([]byte(line[cut:]))
+			}
+		}
+		w.Write([]byte("the last"))
+		w.Close()
+
+		files, _ := filepath.Glob(path.Join(dir, "record.log.*"))
+		assert.True(t, len(files) > 4)
+		var lines int
+		for _, file := range files {
+			b, _ := os.ReadFile(file)
+			assert.Equal(t, 0, len(b)%len(line), file)
+			for i := 0; i < len(b); i += len(line) {
+				assert.Equal(t, line, string(b[i:i+len(line)]))
+				lines++
+			}
+		}
+		b, _ := os.ReadFile(path.Join(dir, "record.log"))
+		assert.True(t, strings.HasSuffix(string(b), "the last"))
+		lines += strings.Count(string(b), "\n")
+		assert.Equal(t, 104, lines)
+	}
+
+	assert.Equal(t, "0123456789\n", string(splitChunk([]byte("0123456789\nabc"), 4, true)))
+	assert.Equal(t, "0123", string(splitChunk([]byte("0123456789\nabc"), 4, false)))
+}
+
+func TestBufferWriterStress(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(WithLogPath(dir), WithFileName("stress"), WithoutRollingPolicy(),
+		WithTimeTagFormat("20060102150405,000000000"), WithBuffer(), WithBufferThershould(256),
+		WithFlushInterval(time.Millisecond))
+	assert.Nil(t, err)
+
+	const writers, lines = 8, 2000
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				w.Write([]byte(strconv.Itoa(i) + " " + strconv.Itoa(j) + "\n"))
+				switch {
+				case j%500 == 0:
+					assert.Nil(t, w.Rotate())
+				case j%100 == 0:
+					assert.Nil(t, w.Flush())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Nil(t, w.Close())
+	_, err = w.Write([]byte("write after close\n"))
+	assert.Equal(t, ErrClosed, err)
+
+	// the backups are in rolling order by the time tag
+	files, _ := filepath.Glob(path.Join(dir, "stress.log.*"))
+	files = append(files, path.Join(dir, "stress.log"))
+	next := make([]int, writers)
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		assert.Nil(t, err)
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			var i, j int
+			fields := strings.Fields(line)
+			i, _ = strconv.Atoi(fields[0])
+			j, _ = strconv.Atoi(fields[1])
+			// every writer's lines are in order without loss
+			assert.Equal(t, next[i], j, line)
+			next[i] = j + 1
+		}
+	}
+	for i := 0; i < writers; i++ {
+		assert.Equal(t, lines, next[i])
+	}
+}
+
+func TestConcurrentRolling(t *testing.T) {
+	dir := t.TempDir()
+	cfg := NewDefaultConfig()
+	for _, opt := range []Option{WithLogPath(dir), WithFileName("concurrent"),
+		WithTimeTagFormat("20060102150405,000000000"), WithRollingVolumeSize("16k"), WithCompress()} {
+		opt(&cfg)
+	}
+	cfg.WriterMode = "none"
+	w, err := NewWriterFromConfig(&cfg)
+	assert.Nil(t, err)
+
+	const writers, lines = 8, 1000
+	line := []byte("write and roll concurrently without a lock\n")
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				_, err := w.Write(line)
+				assert.Nil(t, err)
+				if i == 0 && j%100 == 0 {
+					assert.Nil(t, w.Rotate())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Nil(t, w.Close())
+
+	files, _ := filepath.Glob(path.Join(dir, "concurrent.log*"))
+	var total int
+	for _, file := range files {
+		f, err := os.Open(file)
+		assert.Nil(t, err)
+		var r io.Reader = f
+		if file != path.Join(dir, "concurrent.log") {
+			r, err = gzip.NewReader(f)
+			assert.Nil(t, err, file)
+		}
+		b, err := io.ReadAll(r)
+		assert.Nil(t, err, file)
+		assert.Equal(t, 0, len(b)%len(line), file)
+		total += len(b)
+		f.Close()
+	}
+	assert.Equal(t, writers*lines*len(line), total)
+}
+
+// batchMetrics record the file writes, stall the first one until released
+type batchMetrics struct {
+	nopMetrics
+	release chan struct{}
+	lock    sync.Mutex
+	writes  []int
+}
+
+func (m *batchMetrics) BytesWritten(n int) {
+	<-m.release
+	m.lock.Lock()
+	m.writes = append(m.writes, n)
+	m.lock.Unlock()
+}
+
+func TestAsyncBatch(t *testing.T) {
+	dir := t.TempDir()
+	m := &batchMetrics{release: make(chan struct{})}
+	w, err := NewWriter(WithLogPath(dir), WithFileName("batch"), WithoutRollingPolicy(),
+		WithAsynchronous(), WithAsyncBatch("1k", 0), WithMetrics(m))
+	assert.Nil(t, err)
+
+	var expected []byte
+	for i := 0; i < 100; i++ {
+		line := []byte(strconv.Itoa(i) + " coalesced into the batch\n")
+		expected = append(expected, line...)
+		_, err := w.Write(line)
+		assert.Nil(t, err)
+	}
+	// the writes queued during the stall are coalesced
+	close(m.release)
+	assert.Nil(t, w.Close())
+
+	b, _ := os.ReadFile(path.Join(dir, "batch.log"))
+	assert.Equal(t, string(expected), string(b))
+	assert.True(t, len(m.writes) < 10, m.writes)
+	// the first write may be taken before the others queued
+	for _, n := range m.writes[1 : len(m.writes)-1] {
+		assert.True(t, n >= 1024, m.writes)
+	}
+
+	// the writes coming within the latency are coalesced
+	m = &batchMetrics{release: make(chan struct{})}
+	close(m.release)
+	w, err = NewWriter(WithLogPath(dir), WithFileName("latency"), WithoutRollingPolicy(),
+		WithAsynchronous(), WithAsyncBatch("1m", 200*time.Millisecond), WithMetrics(m))
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		w.Write([]byte("wait for the batch\n"))
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, w.Flush())
+	m.lock.Lock()
+	assert.Equal(t, []int{10 * len("wait for the batch\n")}, m.writes)
+	m.lock.Unlock()
+	w.Close()
+}
+
+func TestAsyncFlushError(t *testing.T) {
+	w, err := NewWriter(WithLogPath(t.TempDir()), WithAsynchronous(), WithoutRollingPolicy())
+	assert.Nil(t, err)
+	aw := w.(*AsynchronousWriter)
+
+	// the queued write fails on the closed file
+	aw.current().file.Close()
+	_, err = w.Write([]byte("lost\n"))
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(aw.Flush(), os.ErrClosed))
+
+	// the error is returned by the Flush after the failed write only
+	_, err = w.Write([]byte("lost\n"))
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(aw.Flush(), os.ErrClosed))
+	assert.Nil(t, aw.Flush())
+	w.Close()
+}
+
+func TestAsyncBatchSize(t *testing.T) {
+	w, err := NewWriter(WithLogPath(t.TempDir()), WithAsynchronous(), WithAsyncBatch("4096", 0))
+	assert.Nil(t, err)
+	assert.Equal(t, 4096, w.(*AsynchronousWriter).batchSize)
+	w.Close()
+
+	for _, size := range []string{"abc", "0", "1.5k"} {
+		_, err := NewWriter(WithLogPath(t.TempDir()), WithAsynchronous(), WithAsyncBatch(size, 0))
+		assert.Equal(t, ErrInvalidArgument, err)
+	}
+}